@@ -2,7 +2,10 @@ package dragonfly
 
 import (
 	"context"
+	"iter"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,15 +13,16 @@ import (
 	"github.com/LukeEuler/dolly/log"
 )
 
+// ErrClosed Dragonfly 被 Close, 或者 Start 传入的 ctx 已结束
+var ErrClosed = errors.New("dragonfly closed")
+
 /*
-TODO
-inputs, outputs 目前是每次Get时, 创建并销毁
-应该升级成长期存活的状态
+Dragonfly implement IDragonfly
+
+worker 及各自的 inputs, outputs 在 Start 时创建, 在多次 Get 之间复用, 直到 Close
+可以同时 Get, 各个 Get 的 box 由 schedule 协程轮流放入 inputs, 结果及错误互不影响
 */
 type Dragonfly[T any, R any] struct {
-	inUse bool
-	mutex sync.RWMutex
-
 	// worker 工厂，将具体的业务逻辑从 dragonfly 中剥离
 	generate Factory[T, R]
 
@@ -27,17 +31,28 @@ type Dragonfly[T any, R any] struct {
 	redundancy int
 	split      int
 	maxTry     int
-	workLength int // 通用工作空间，inputs,outputs,queue 的大小
+	workLength int // 通用工作空间，inputs,outputs 的大小
 
-	// 每次运行都需要初始化
-	inputs  chan *box[T, R]
-	outputs chan *box[T, R]
-	cursor  cursor
-}
+	// 以下在 Start 时交给 schedule 协程
+	backoff  Backoff // box 失败后, 重新放入 inputs 前的等待时间
+	adaptive bool    // box 多次失败后, 拆分为两半重试
+	progress func(Stats)
+	limits   []WorkerLimit // 各个 worker 的权重及限速
+
+	metrics *metrics
+	rounds  atomic.Int64 // schedule 协程的循环次数, 用于检查是否空转
 
-type cursor struct {
-	idx int
-	len int
+	/*
+		worker pool, 由 poolMutex 保护
+		ctx 结束后, 所有 worker 及 schedule 协程都会退出, running 用于等待其退出
+		register 用于向 schedule 协程提交新的 Get
+	*/
+	poolMutex sync.Mutex
+	started   bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	running   *sync.WaitGroup
+	register  chan *call[T, R]
 }
 
 func NewDragonfly[T any, R any](concurrent, redundancy, split, maxTry int, wf Factory[T, R]) *Dragonfly[T, R] {
@@ -54,24 +69,123 @@ func NewDragonfly[T any, R any](concurrent, redundancy, split, maxTry int, wf Fa
 		maxTry:     maxTry,
 		workLength: workLength,
 		generate:   wf,
-		cursor:     cursor{},
+		backoff:    ConstantBackoff(time.Second),
+		metrics:    new(metrics),
+	}
+}
+
+// SetBackoff 默认为 ConstantBackoff(time.Second), nil 表示立即重试, 在下一次 Start 时生效
+func (d *Dragonfly[T, R]) SetBackoff(backoff Backoff) *Dragonfly[T, R] {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+	d.backoff = backoff
+	return d
+}
+
+/*
+SetAdaptiveSplit 开启后, 包含多个元素的 box 连续失败 min(2, maxTry) 次时, 拆分为两半分别重试(不计入 maxTry)
+直至只包含一个元素, 再按 maxTry 重试, 从而隔离出有问题的元素, 正常的 box 不受影响
+
+每次 Get 可拆分的次数有限: 初始为 box 的个数, 每成功一个 box 增加一次, 用完后按 maxTry 重试
+所以节点完全不可用时, 请求数最多为不拆分时的 3 倍, 而不是 split 倍
+在下一次 Start 时生效
+*/
+func (d *Dragonfly[T, R]) SetAdaptiveSplit(adaptive bool) *Dragonfly[T, R] {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+	d.adaptive = adaptive
+	return d
+}
+
+/*
+Start 创建 worker pool, 以 ctx 为生命周期
+ctx 结束后, Get 返回 ErrClosed, 此时需要 Close 后才能重新使用
+
+不调用 Start 时, 第一次 Get 会以 context.Background() 自动开始
+*/
+func (d *Dragonfly[T, R]) Start(ctx context.Context) error {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+	if d.started {
+		return errors.New("dragonfly already started")
+	}
+	return d.startLocked(ctx)
+}
+
+func (d *Dragonfly[T, R]) startLocked(ctx context.Context) error {
+	// 先创建全部 worker, 避免创建失败时还要清理已经运行的协程
+	workers := make([]Worker[T, R], 0, d.concurrent)
+	for i := 0; i < d.concurrent; i++ {
+		worker, err := d.generate()
+		if err != nil {
+			return err
+		}
+		workers = append(workers, worker)
+	}
+
+	d.ctx, d.cancel = context.WithCancel(ctx)
+	d.register = make(chan *call[T, R])
+	d.running = new(sync.WaitGroup)
+	r := newRouter[T, R](d.limits, d.concurrent, d.workLength, time.Now())
+	outputs := make(chan *box[T, R], d.workLength)
+	for i, worker := range workers {
+		d.running.Add(1)
+		go func(w Worker[T, R], ctx context.Context, running *sync.WaitGroup, inputs chan *box[T, R]) {
+			defer running.Done()
+			w(ctx, inputs, outputs)
+		}(worker, d.ctx, d.running, r.routes[i].in)
 	}
+	d.running.Add(1)
+	go func(ctx context.Context, running *sync.WaitGroup, register chan *call[T, R], p policy) {
+		defer running.Done()
+		d.schedule(ctx, register, r, outputs, p)
+	}(d.ctx, d.running, d.register, policy{backoff: d.backoff, adaptive: d.adaptive, progress: d.progress})
+	d.started = true
+	return nil
 }
 
+// Close 通知所有 worker 退出并等待其结束, 之后可以重新 Start
+func (d *Dragonfly[T, R]) Close() {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+	if !d.started {
+		return
+	}
+	d.cancel()
+	d.running.Wait()
+	d.metrics.reset()
+	d.started = false
+	d.ctx, d.cancel, d.running, d.register = nil, nil, nil, nil
+}
+
+// pool 返回当前的 worker pool, 未开始时自动开始
+func (d *Dragonfly[T, R]) pool() (context.Context, chan *call[T, R], error) {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+	if !d.started {
+		err := d.startLocked(context.Background())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return d.ctx, d.register, nil
+}
+
+// Get 当 f 对每个输入返回一个结果时, result[i] 对应 list[i]; 否则按 box 的顺序拼接
 func (d *Dragonfly[T, R]) Get(list []T) ([]R, error) {
+	return d.GetContext(context.Background(), list)
+}
+
+/*
+GetContext 同 Get, ctx 结束时立即返回 ctx 的错误
+本次 Get 剩余的 box 不再重试, 还在 inputs 中的 box 会被 worker 跳过
+*/
+func (d *Dragonfly[T, R]) GetContext(ctx context.Context, list []T) ([]R, error) {
 	if len(list) == 0 {
 		return []R{}, nil
 	}
 	start := time.Now()
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	if d.inUse {
-		return nil, errors.New("in use")
-	}
-
-	d.inUse = true
-	result, err := d.get(list)
-	d.inUse = false
+	result, err := d.get(ctx, list)
 	log.Entry.
 		WithField("dragonfly", "GET").
 		WithField("cost", time.Since(start).String()).
@@ -79,96 +193,125 @@ func (d *Dragonfly[T, R]) Get(list []T) ([]R, error) {
 	return result, err
 }
 
-func (d *Dragonfly[T, R]) get(list []T) ([]R, error) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	d.init(list)
-	defer d.clearChannels()
+func (d *Dragonfly[T, R]) get(ctx context.Context, list []T) ([]R, error) {
+	chunks, err := d.wait(ctx, list, false)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]R, 0, len(list))
+	for _, chunk := range chunks {
+		result = append(result, chunk.Results...)
+	}
+	return result, nil
+}
 
-	var wg sync.WaitGroup
-	wg.Add(d.concurrent)
+/*
+GetPartial 某个 box 超出 maxTry 时, 不影响其他的 box
+返回所有成功的结果(按 Start 排序), 有失败的 box 时, error 为 *PartialError
+*/
+func (d *Dragonfly[T, R]) GetPartial(list []T) ([]Chunk[R], error) {
+	if len(list) == 0 {
+		return []Chunk[R]{}, nil
+	}
+	start := time.Now()
+	chunks, err := d.wait(context.Background(), list, true)
+	log.Entry.
+		WithField("dragonfly", "GET").
+		WithField("cost", time.Since(start).String()).
+		Infof("get partial %d", len(list))
+	return chunks, err
+}
 
-	for i := 0; i < d.concurrent; i++ {
-		worker, err := d.generate()
-		if err != nil {
-			cancel()
-			return nil, err
-		}
-		go func(w Worker[T, R]) {
-			defer wg.Done()
-			w(ctx, d.inputs, d.outputs)
-		}(worker)
-	}
-
-	var finalErr error
-	result := make([]R, 0, d.cursor.len)
-	for item := range d.outputs {
-		item.count++
-		if item.Err != nil {
-			log.Entry.WithError(item.Err).Error(item.Err)
-			if item.count > d.maxTry {
-				finalErr = item.Err
-				cancel()
-				break
-			}
-			d.inputs <- item
-			continue
-		}
-		result = append(result, item.Result...)
-		if d.cursor.idx >= d.cursor.len {
-			// 不再需要添加任务
-			if len(result) >= d.cursor.len {
-				// 任务全部完成
-				break
-			}
-			continue
-		}
-		j := d.cursor.idx + d.split
-		j = min(j, d.cursor.len)
-		d.inputs <- &box[T, R]{
-			count: 1,
-			start: d.cursor.idx,
-			end:   j,
-			total: d.cursor.len,
-			In:    list[d.cursor.idx:j],
-		}
-		d.cursor.idx += d.split
+// wait 等待 list 全部完成, 返回按 Start 排序的结果
+func (d *Dragonfly[T, R]) wait(ctx context.Context, list []T, partial bool) ([]Chunk[R], error) {
+	c, err := d.submit(ctx, list, partial)
+	if err != nil {
+		return nil, err
 	}
+	// 返回时通知 worker 跳过本次 Get 剩余的 box
+	defer c.cancel()
 
-	if finalErr != nil {
-		return nil, finalErr
+	select {
+	case <-c.done:
+	case <-c.pool.Done():
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
 	}
-	return result, nil
-	// return result, nil
+	if c.err != nil {
+		return nil, c.err
+	}
+	chunks := c.take()
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Start < chunks[j].Start
+	})
+	if len(c.failed) > 0 {
+		sort.Slice(c.failed, func(i, j int) bool {
+			return c.failed[i].Start < c.failed[j].Start
+		})
+		return chunks, &PartialError{Total: len(list), Failed: c.failed}
+	}
+	return chunks, nil
 }
 
-func (d *Dragonfly[T, R]) init(list []T) {
-	d.cursor.idx = 0
-	d.cursor.len = len(list)
-	d.inputs = make(chan *box[T, R], d.workLength)
-	d.outputs = make(chan *box[T, R], d.workLength)
-
-	for k := 0; k < d.workLength; k++ {
-		if d.cursor.idx >= d.cursor.len {
-			break
+/*
+Unordered 按完成的顺序返回每个 box 的结果, 不等待整个 list 完成
+出错时返回一次 error 后结束, 提前结束迭代时, 剩余的 box 不再处理
+*/
+func (d *Dragonfly[T, R]) Unordered(list []T) iter.Seq2[Chunk[R], error] {
+	return func(yield func(Chunk[R], error) bool) {
+		if len(list) == 0 {
+			return
 		}
-		j := d.cursor.idx + d.split
-		if j > d.cursor.len {
-			j = d.cursor.len
+		c, err := d.submit(context.Background(), list, false)
+		if err != nil {
+			yield(Chunk[R]{}, err)
+			return
 		}
-		d.inputs <- &box[T, R]{
-			count: 1,
-			start: d.cursor.idx,
-			end:   j,
-			total: d.cursor.len,
-			In:    list[d.cursor.idx:j],
+		defer c.cancel()
+
+		for {
+			for _, chunk := range c.take() {
+				if !yield(chunk, nil) {
+					return
+				}
+			}
+			select {
+			case <-c.notify:
+			case <-c.done:
+				for _, chunk := range c.take() {
+					if !yield(chunk, nil) {
+						return
+					}
+				}
+				if c.err != nil {
+					yield(Chunk[R]{}, c.err)
+				}
+				return
+			case <-c.pool.Done():
+				yield(Chunk[R]{}, ErrClosed)
+				return
+			}
 		}
-		d.cursor.idx += d.split
 	}
 }
 
-func (d *Dragonfly[T, R]) clearChannels() {
-	// close(d.outputs)
-	close(d.inputs)
+// submit 将 list 提交给 schedule 协程
+func (d *Dragonfly[T, R]) submit(ctx context.Context, list []T, partial bool) (*call[T, R], error) {
+	poolCtx, register, err := d.pool()
+	if err != nil {
+		return nil, err
+	}
+	c := newCall[T, R](poolCtx, ctx, list, (len(list)+d.split-1)/d.split)
+	c.partial = partial
+	select {
+	case register <- c:
+		return c, nil
+	case <-poolCtx.Done():
+		c.cancel()
+		return nil, ErrClosed
+	case <-ctx.Done():
+		c.cancel()
+		return nil, errors.WithStack(ctx.Err())
+	}
 }
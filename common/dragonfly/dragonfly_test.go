@@ -1,7 +1,12 @@
 package dragonfly
 
 import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -35,3 +40,576 @@ func TestNewDragonfly(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, result, 7)
 }
+
+func TestDragonflyPool(t *testing.T) {
+	var clients atomic.Int64
+	nextClient := func() (tree.Context, error) {
+		clients.Add(1)
+		return nil, nil
+	}
+	d := NewDragonfly(3, 1, 2, 1, NewWorkerFactory(nextClient, testNegativeFunc))
+	assert.NoError(t, d.Start(context.Background()))
+	assert.Error(t, d.Start(context.Background()))
+
+	for i := 0; i < 10; i++ {
+		result, err := d.Get([]int{1, 2, 3, 4, 5})
+		assert.NoError(t, err)
+		assert.Len(t, result, 5)
+	}
+	// 失败不影响之后的 Get
+	_, err := d.Get([]int{1, 2, -3, 4, 5, 6, 7})
+	assert.ErrorContains(t, err, "negative")
+	result, err := d.Get([]int{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Len(t, result, 3)
+	assert.Equal(t, int64(3), clients.Load())
+
+	d.Close()
+	// Close 后自动重新开始
+	result, err = d.Get([]int{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Len(t, result, 3)
+	assert.Equal(t, int64(6), clients.Load())
+	d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, d.Start(ctx))
+	cancel()
+	_, err = d.Get([]int{1, 2, 3})
+	assert.ErrorIs(t, err, ErrClosed)
+	d.Close()
+}
+
+func TestDragonflyConcurrentGet(t *testing.T) {
+	d := NewDragonfly(3, 2, 2, 2, NewWorkerFactory(testNextClient, func(ctx tree.Context, in []int) ([]int64, error) {
+		time.Sleep(time.Millisecond)
+		return testNegativeFunc(ctx, in)
+	})).SetBackoff(nil)
+	defer d.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			list := make([]int, 0, 50)
+			for k := 0; k < 50; k++ {
+				list = append(list, i*100+k)
+			}
+			if i%4 == 3 {
+				// 出错的 Get 不影响其他的 Get
+				list[20] = -1
+				_, err := d.Get(list)
+				assert.ErrorContains(t, err, "negative")
+				return
+			}
+			result, err := d.Get(list)
+			assert.NoError(t, err)
+			assert.Len(t, result, 50)
+			for _, v := range result {
+				assert.Equal(t, int64(i), (v-1000)/100)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func testSlowFunc(_ tree.Context, in []int) ([]int64, error) {
+	// 让 box 乱序完成
+	time.Sleep(time.Duration(in[0]%5) * time.Millisecond)
+	return func1(nil, in)
+}
+
+func TestDragonflyOrder(t *testing.T) {
+	d := NewDragonfly(4, 2, 3, 1, NewWorkerFactory(testNextClient, testSlowFunc))
+	defer d.Close()
+
+	list := make([]int, 0, 100)
+	for i := 0; i < 100; i++ {
+		list = append(list, i)
+	}
+	result, err := d.Get(list)
+	assert.NoError(t, err)
+	for i, v := range result {
+		assert.Equal(t, int64(list[i]+1000), v)
+	}
+
+	seen := make([]bool, len(list))
+	for chunk, err := range d.Unordered(list) {
+		assert.NoError(t, err)
+		assert.Len(t, chunk.Results, chunk.End-chunk.Start)
+		for i, v := range chunk.Results {
+			assert.Equal(t, int64(list[chunk.Start+i]+1000), v)
+			seen[chunk.Start+i] = true
+		}
+	}
+	assert.NotContains(t, seen, false)
+
+	// 提前结束
+	count := 0
+	for _, err := range d.Unordered(list) {
+		assert.NoError(t, err)
+		count++
+		if count == 2 {
+			break
+		}
+	}
+	assert.Equal(t, 2, count)
+	result, err = d.Get(list[:10])
+	assert.NoError(t, err)
+	assert.Len(t, result, 10)
+}
+
+func testNegativeFunc(_ tree.Context, in []int) ([]int64, error) {
+	for _, v := range in {
+		if v < 0 {
+			return nil, errors.New("negative")
+		}
+	}
+	return func1(nil, in)
+}
+
+func TestDragonflyPartial(t *testing.T) {
+	d := NewDragonfly(2, 1, 3, 1, NewWorkerFactory(testNextClient, testNegativeFunc))
+	defer d.Close()
+
+	list := make([]int, 0, 20)
+	for i := 0; i < 20; i++ {
+		list = append(list, i)
+	}
+	list[5], list[17] = -1, -1
+	chunks, err := d.GetPartial(list)
+	var partial *PartialError
+	assert.ErrorAs(t, err, &partial)
+	assert.Equal(t, 20, partial.Total)
+	assert.Len(t, partial.Failed, 2)
+	assert.Equal(t, 3, partial.Failed[0].Start)
+	assert.Equal(t, 6, partial.Failed[0].End)
+	assert.Equal(t, 15, partial.Failed[1].Start)
+	assert.Equal(t, 18, partial.Failed[1].End)
+	assert.ErrorContains(t, partial.Failed[1].Err, "negative")
+	assert.ErrorContains(t, err, "6/20 failed")
+
+	count := 0
+	last := -1
+	for _, chunk := range chunks {
+		assert.Greater(t, chunk.Start, last)
+		last = chunk.Start
+		for i, v := range chunk.Results {
+			assert.Equal(t, int64(list[chunk.Start+i]+1000), v)
+			count++
+		}
+	}
+	assert.Equal(t, 14, count)
+
+	chunks, err = d.GetPartial(list[:3])
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 1)
+}
+
+func TestDragonflyContext(t *testing.T) {
+	var calls atomic.Int64
+	d := NewDragonfly(2, 0, 1, 3, NewWorkerFactory(testNextClient, func(ctx tree.Context, in []int) ([]int64, error) {
+		calls.Add(1)
+		if in[0] < 0 {
+			return nil, errors.New("negative")
+		}
+		time.Sleep(10 * time.Millisecond)
+		return func1(ctx, in)
+	})).SetBackoff(ExponentialBackoff(20*time.Millisecond, time.Second))
+	defer d.Close()
+
+	// 重试的等待时间 20ms + 40ms
+	start := time.Now()
+	_, err := d.Get([]int{-1})
+	assert.ErrorContains(t, err, "negative")
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+	assert.Equal(t, int64(3), calls.Load())
+
+	// 等待重试时 ctx 结束
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	list := make([]int, 100)
+	list[0] = -1
+	_, err = d.GetContext(ctx, list)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 60*time.Millisecond)
+
+	// 剩余的 box 不再处理
+	time.Sleep(30 * time.Millisecond)
+	calls.Store(0)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int64(0), calls.Load())
+
+	result, err := d.GetContext(context.Background(), []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1001, 1002}, result)
+}
+
+func TestDragonflyContextWorker(t *testing.T) {
+	var interrupted atomic.Int64
+	d := NewDragonfly(2, 0, 1, 3, NewContextWorkerFactory(testNextClient,
+		func(ctx context.Context, client tree.Context, in []int) ([]int64, error) {
+			if in[0] < 0 {
+				// 进行中的请求, 直到 ctx 被取消
+				<-ctx.Done()
+				interrupted.Add(1)
+				return nil, ctx.Err()
+			}
+			return func1(client, in)
+		}, Health{MaxFailures: 1}))
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := d.GetContext(ctx, []int{1, -1})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Eventually(t, func() bool {
+		return interrupted.Load() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	result, err := d.Get([]int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1001, 1002}, result)
+}
+
+func TestDragonflyAdaptiveSplit(t *testing.T) {
+	d := NewDragonfly(2, 1, 8, 2, NewWorkerFactory(testNextClient, func(ctx tree.Context, in []int) ([]int64, error) {
+		if len(in) > 4 {
+			return nil, errors.New("payload too large")
+		}
+		return testNegativeFunc(ctx, in)
+	})).SetBackoff(nil).SetAdaptiveSplit(true)
+	defer d.Close()
+
+	list := make([]int, 0, 40)
+	for i := 0; i < 40; i++ {
+		list = append(list, i)
+	}
+	result, err := d.Get(list)
+	assert.NoError(t, err)
+	assert.Len(t, result, 40)
+	for i, v := range result {
+		assert.Equal(t, int64(list[i]+1000), v)
+	}
+
+	// 隔离出有问题的元素
+	list[13] = -1
+	chunks, err := d.GetPartial(list)
+	var partial *PartialError
+	assert.ErrorAs(t, err, &partial)
+	assert.Len(t, partial.Failed, 1)
+	assert.Equal(t, 13, partial.Failed[0].Start)
+	assert.Equal(t, 14, partial.Failed[0].End)
+	assert.Equal(t, 2, partial.Failed[0].Tries)
+	count := 0
+	for _, chunk := range chunks {
+		count += len(chunk.Results)
+	}
+	assert.Equal(t, 39, count)
+}
+
+func TestDragonflyAdaptiveSplitOutage(t *testing.T) {
+	var calls atomic.Int64
+	down := func(tree.Context, []int) ([]int64, error) {
+		calls.Add(1)
+		return nil, errors.New("node down")
+	}
+	list := make([]int, 16)
+
+	d := NewDragonfly(2, 0, 8, 2, NewWorkerFactory(testNextClient, down)).SetBackoff(nil)
+	_, err := d.GetPartial(list)
+	assert.Error(t, err)
+	d.Close()
+	plain := calls.Swap(0)
+	assert.Equal(t, int64(4), plain)
+
+	// 节点不可用时, 拆分次数受限, 而不是拆分到单个元素
+	d = NewDragonfly(2, 0, 8, 2, NewWorkerFactory(testNextClient, down)).SetBackoff(nil).SetAdaptiveSplit(true)
+	_, err = d.GetPartial(list)
+	assert.Error(t, err)
+	d.Close()
+	assert.LessOrEqual(t, calls.Load(), 3*plain)
+}
+
+func TestDragonflyStream(t *testing.T) {
+	d := NewDragonfly(3, 1, 4, 1, NewWorkerFactory(testNextClient, testSlowFunc))
+	defer d.Close()
+
+	var produced atomic.Int64
+	input := func(yield func(int) bool) {
+		for i := 0; i < 1000; i++ {
+			produced.Add(1)
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	consumed := 0
+	for chunk, err := range d.Stream(context.Background(), input, 50) {
+		assert.NoError(t, err)
+		assert.Equal(t, consumed, chunk.Start)
+		for i, v := range chunk.Results {
+			assert.Equal(t, int64(chunk.Start+i+1000), v)
+		}
+		consumed = chunk.End
+		// 最多预先读取两批, 以及 input 中等待被读取的一个
+		assert.LessOrEqual(t, produced.Load()-int64(consumed), int64(101))
+	}
+	assert.Equal(t, 1000, consumed)
+
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 30; i++ {
+			if i == 25 {
+				ch <- -1
+				continue
+			}
+			ch <- i
+		}
+	}()
+	consumed = 0
+	var last error
+	d = NewDragonfly(3, 1, 4, 1, NewWorkerFactory(testNextClient, testNegativeFunc))
+	defer d.Close()
+	for chunk, err := range d.StreamChan(context.Background(), ch, 10) {
+		last = err
+		consumed += len(chunk.Results)
+	}
+	assert.ErrorContains(t, last, "negative")
+	// 出错的批次之前的结果, 批次的大小取决于 input 的速度
+	assert.LessOrEqual(t, consumed, 25)
+
+	// input 需要等待结果才能继续产生时, 不等待凑满 window
+	slow := make(chan int)
+	results := make(chan int, 10)
+	go func() {
+		defer close(slow)
+		for i := 0; i < 10; i++ {
+			if i == 5 {
+				for range 5 {
+					<-results
+				}
+			}
+			slow <- i
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	consumed = 0
+	for chunk, err := range d.StreamChan(ctx, slow, 10) {
+		assert.NoError(t, err)
+		assert.Equal(t, consumed, chunk.Start)
+		consumed = chunk.End
+		for range chunk.Results {
+			results <- 0
+		}
+	}
+	assert.Equal(t, 10, consumed)
+
+	// 提前结束时, input 返回后 Stream 才返回
+	var running atomic.Bool
+	endless := func(yield func(int) bool) {
+		running.Store(true)
+		defer running.Store(false)
+		for i := 0; ; i++ {
+			time.Sleep(time.Millisecond)
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	for _, err := range d.Stream(context.Background(), endless, 10) {
+		assert.NoError(t, err)
+		break
+	}
+	assert.False(t, running.Load())
+
+	// StreamChan 提前结束时, 不需要等待 channel 被关闭
+	open := make(chan int)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case open <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for _, err := range d.StreamChan(context.Background(), open, 10) {
+		assert.NoError(t, err)
+		break
+	}
+}
+
+func TestDragonflyHealth(t *testing.T) {
+	var generation atomic.Int64
+	nextClient := func() (tree.Context, error) {
+		ctx := tree.NewDefaultContext()
+		ctx.Set("generation", generation.Add(1))
+		return ctx, nil
+	}
+	var events []ReplaceEvent
+	d := NewDragonfly(1, 0, 2, 5, NewWorkerFactoryWithHealth(nextClient, func(ctx tree.Context, in []int) ([]int64, error) {
+		g, err := tree.Get[int64](ctx, "generation")
+		if err != nil {
+			return nil, err
+		}
+		if g == 1 {
+			return nil, errors.New("bad node")
+		}
+		return func1(ctx, in)
+	}, Health{
+		MaxFailures: 2,
+		OnReplace: func(event ReplaceEvent) {
+			events = append(events, event)
+		},
+	})).SetBackoff(nil)
+	defer d.Close()
+
+	result, err := d.Get([]int{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1001, 1002, 1003}, result)
+	assert.Len(t, events, 1)
+	assert.Equal(t, int64(1), events[0].Worker)
+	assert.Equal(t, 2, events[0].Failures)
+	assert.ErrorContains(t, events[0].Err, "bad node")
+	assert.NoError(t, events[0].NewErr)
+	assert.Equal(t, int64(2), generation.Load())
+}
+
+func TestDragonflyStats(t *testing.T) {
+	var failed atomic.Bool
+	var mutex sync.Mutex
+	var progress []Stats
+	d := NewDragonfly(2, 0, 4, 2, NewWorkerFactory(testNextClient, func(ctx tree.Context, in []int) ([]int64, error) {
+		if in[0] == 8 && failed.CompareAndSwap(false, true) {
+			return nil, errors.New("once")
+		}
+		time.Sleep(time.Millisecond)
+		return func1(ctx, in)
+	})).SetBackoff(nil).SetProgress(func(stats Stats) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		progress = append(progress, stats)
+	})
+	defer d.Close()
+
+	list := make([]int, 40)
+	for i := range list {
+		list[i] = i
+	}
+	_, err := d.Get(list)
+	assert.NoError(t, err)
+
+	// 10 个 box 完成, 1 次失败; 最后一次可能在 Get 返回之后
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(progress) == 11
+	}, time.Second, time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	last := progress[len(progress)-1]
+	assert.Equal(t, 1, last.Calls)
+	assert.Equal(t, 40, last.Total)
+	assert.Equal(t, 40, last.Completed)
+	assert.Equal(t, 0, last.Remaining())
+	assert.Equal(t, int64(1), last.Retries)
+	assert.Equal(t, int64(10), last.Chunks)
+	assert.GreaterOrEqual(t, last.P50, time.Millisecond)
+	assert.GreaterOrEqual(t, last.P99, last.P50)
+	assert.Greater(t, last.Rate, 0.0)
+	for _, one := range progress[:len(progress)-1] {
+		assert.LessOrEqual(t, one.Completed, 40)
+		if one.Remaining() > 0 {
+			assert.Greater(t, one.ETA, time.Duration(0))
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return d.Stats().Calls == 0
+	}, time.Second, time.Millisecond)
+	stats := d.Stats()
+	assert.Equal(t, 0, stats.Total)
+	assert.Equal(t, int64(10), stats.Chunks)
+}
+
+func TestDragonflyWorkerLimits(t *testing.T) {
+	var generation atomic.Int64
+	nextClient := func() (tree.Context, error) {
+		ctx := tree.NewDefaultContext()
+		ctx.Set("worker", generation.Add(1)-1)
+		return ctx, nil
+	}
+	var counts [3]atomic.Int64
+	d := NewDragonfly(3, 3, 1, 1, NewWorkerFactory(nextClient, func(ctx tree.Context, in []int) ([]int64, error) {
+		worker, err := tree.Get[int64](ctx, "worker")
+		if err != nil {
+			return nil, err
+		}
+		counts[worker].Add(1)
+		time.Sleep(2 * time.Millisecond)
+		return func1(ctx, in)
+	})).SetWorkerLimits(WorkerLimit{Rate: 20}, WorkerLimit{Weight: 3})
+	defer d.Close()
+
+	list := make([]int, 60)
+	for i := range list {
+		list[i] = i
+	}
+	start := time.Now()
+	result, err := d.Get(list)
+	assert.NoError(t, err)
+	assert.Len(t, result, 60)
+	// 被限速的 worker 只分到令牌允许的数量, 其余的 box 分给了其他 worker
+	assert.LessOrEqual(t, counts[0].Load(), int64(time.Since(start).Seconds()*20)+1)
+	assert.Equal(t, int64(60), counts[0].Load()+counts[1].Load()+counts[2].Load())
+}
+
+func TestDragonflyThrottledRetry(t *testing.T) {
+	var failed atomic.Bool
+	d := NewDragonfly(1, 0, 1, 2, NewWorkerFactory(testNextClient, func(ctx tree.Context, in []int) ([]int64, error) {
+		if failed.CompareAndSwap(false, true) {
+			return nil, errors.New("once")
+		}
+		return func1(ctx, in)
+	})).SetBackoff(nil).SetWorkerLimits(WorkerLimit{Rate: 5})
+	defer d.Close()
+
+	start := time.Now()
+	result, err := d.Get([]int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1001}, result)
+	// 重试已到时间, 但 worker 被限速, 等待令牌而不是空转
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	assert.Less(t, d.rounds.Load(), int64(20))
+}
+
+func TestRouterWeight(t *testing.T) {
+	now := time.Now()
+	r := newRouter[int, int64]([]WorkerLimit{{Weight: 3}, {}, {Rate: 1, Burst: 2}}, 3, 100, now)
+	counts := make([]int, 3)
+	for i := 0; i < 50; i++ {
+		index, _ := r.choose(now)
+		assert.GreaterOrEqual(t, index, 0)
+		item := &box[int, int64]{}
+		r.assign(index, item)
+		counts[index]++
+		<-r.routes[index].in
+	}
+	// 第三个 worker 只有 2 个令牌, 其余按 3:1 分配
+	assert.Equal(t, []int{36, 12, 2}, counts)
+
+	// 所有未满的 worker 都没有令牌时, 返回最早获得令牌的时间
+	r = newRouter[int, int64]([]WorkerLimit{{Rate: 2}}, 1, 1, now)
+	r.assign(0, &box[int, int64]{})
+	r.release(&box[int, int64]{})
+	index, wake := r.choose(now)
+	assert.Equal(t, -1, index)
+	assert.Equal(t, now.Add(500*time.Millisecond), wake)
+}
@@ -0,0 +1,38 @@
+package dragonfly
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FailedRange 超出 maxTry 的 box, 对应输入 list[Start:End]
+type FailedRange struct {
+	Start int
+	End   int
+	Tries int   // 尝试的次数
+	Err   error // 最后一次的错误
+}
+
+// PartialError GetPartial 中部分 box 失败
+type PartialError struct {
+	Total  int // 输入的总数
+	Failed []FailedRange
+}
+
+func (e *PartialError) Error() string {
+	failed := 0
+	ranges := make([]string, 0, len(e.Failed))
+	for _, one := range e.Failed {
+		failed += one.End - one.Start
+		ranges = append(ranges, fmt.Sprintf("%d~%d: %v", one.Start, one.End, one.Err))
+	}
+	return fmt.Sprintf("%d/%d failed [%s]", failed, e.Total, strings.Join(ranges, "; "))
+}
+
+func (e *PartialError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, one := range e.Failed {
+		errs = append(errs, one.Err)
+	}
+	return errs
+}
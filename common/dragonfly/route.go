@@ -0,0 +1,144 @@
+package dragonfly
+
+import (
+	"math"
+	"time"
+)
+
+// WorkerLimit 单个 worker 的权重及限速
+type WorkerLimit struct {
+	Weight int     // 权重越大, 分到的 box 越多, <= 0 时为 1
+	Rate   float64 // 每秒最多分到的 box 数, <= 0 时不限速
+	Burst  int     // 令牌桶容量, 即空闲后最多连续分到的 box 数, <= 0 时为 1
+}
+
+/*
+SetWorkerLimits 按 worker 的创建顺序(即 Factory 的调用顺序)设置权重及限速, 超出 limits 的 worker 使用默认值
+在下一次 Start 时生效
+
+通常 nextClient 按顺序轮流返回不同的节点, 此时 worker i 对应第 i % len(nodes) 个节点
+*/
+func (d *Dragonfly[T, R]) SetWorkerLimits(limits ...WorkerLimit) *Dragonfly[T, R] {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+	d.limits = limits
+	return d
+}
+
+// route 单个 worker 的 inputs 及分配状态, 只在 schedule 协程中读写
+type route[T any, R any] struct {
+	in       chan *box[T, R]
+	weight   int
+	rate     float64
+	burst    float64
+	capacity int // 最多同时分到的 box 数, 即 in 的大小
+
+	assigned int // 已分到, 尚未从 outputs 取回的 box 数
+	current  int // 平滑加权轮询的当前权重
+	tokens   float64
+	updated  time.Time
+}
+
+/*
+router 在各个 worker 之间分配 box
+
+每个 worker 有独立的 inputs, 其大小按权重分配 workLength, 已满或没有令牌的 worker 不参与分配,
+box 会分给其他 worker, 而不是在被限速的 worker 上排队
+在可以分配的 worker 中, 按平滑加权轮询选择
+*/
+type router[T any, R any] struct {
+	routes []*route[T, R]
+}
+
+func newRouter[T any, R any](limits []WorkerLimit, concurrent, workLength int, now time.Time) *router[T, R] {
+	weights := make([]int, concurrent)
+	total := 0
+	for i := range weights {
+		weights[i] = 1
+		if i < len(limits) && limits[i].Weight > 0 {
+			weights[i] = limits[i].Weight
+		}
+		total += weights[i]
+	}
+
+	r := &router[T, R]{routes: make([]*route[T, R], 0, concurrent)}
+	for i, weight := range weights {
+		one := &route[T, R]{
+			weight:   weight,
+			burst:    1,
+			capacity: max(1, (workLength*weight+total-1)/total),
+			updated:  now,
+		}
+		if i < len(limits) {
+			one.rate = max(limits[i].Rate, 0)
+			one.burst = float64(max(limits[i].Burst, 1))
+		}
+		one.tokens = one.burst
+		one.in = make(chan *box[T, R], one.capacity)
+		r.routes = append(r.routes, one)
+	}
+	return r
+}
+
+// refill 按经过的时间补充令牌
+func (one *route[T, R]) refill(now time.Time) {
+	if one.rate <= 0 {
+		return
+	}
+	one.tokens = min(one.burst, one.tokens+now.Sub(one.updated).Seconds()*one.rate)
+	one.updated = now
+}
+
+// available 未满且有令牌
+func (one *route[T, R]) available() bool {
+	return one.assigned < one.capacity && (one.rate <= 0 || one.tokens >= 1)
+}
+
+/*
+choose 返回下一个分配 box 的 worker, 不修改分配状态
+没有可以分配的 worker 时 index 为 -1, 若有未满但没有令牌的 worker, wake 为最早获得令牌的时间
+*/
+func (r *router[T, R]) choose(now time.Time) (index int, wake time.Time) {
+	index = -1
+	best := math.MinInt
+	for i, one := range r.routes {
+		one.refill(now)
+		if one.available() {
+			if one.current+one.weight > best {
+				index, best = i, one.current+one.weight
+			}
+			continue
+		}
+		if one.assigned < one.capacity {
+			due := now.Add(time.Duration((1 - one.tokens) / one.rate * float64(time.Second)))
+			if wake.IsZero() || due.Before(wake) {
+				wake = due
+			}
+		}
+	}
+	return
+}
+
+// assign 将 box 分给 choose 选出的 worker, in 未满, 不会阻塞
+func (r *router[T, R]) assign(index int, item *box[T, R]) {
+	total := 0
+	for _, one := range r.routes {
+		if one.available() {
+			one.current += one.weight
+			total += one.weight
+		}
+	}
+	one := r.routes[index]
+	one.current -= total
+	one.assigned++
+	if one.rate > 0 {
+		one.tokens--
+	}
+	item.worker = index
+	one.in <- item
+}
+
+// release box 已从 outputs 取回
+func (r *router[T, R]) release(item *box[T, R]) {
+	r.routes[item.worker].assigned--
+}
@@ -0,0 +1,314 @@
+package dragonfly
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/LukeEuler/dolly/log"
+)
+
+// Chunk 一个 box 的结果, 对应输入 list[Start:End]
+type Chunk[R any] struct {
+	Start   int
+	End     int
+	Results []R
+}
+
+/*
+call 一次 Get 的状态, 除 ctx, done 以及由 mutex 保护的 chunks 外, 只在 schedule 协程中读写
+done 关闭后, Get 才能读取 err
+*/
+type call[T any, R any] struct {
+	pool   context.Context // worker pool 的 ctx
+	ctx    context.Context // Get 的 ctx, pool 结束时同样结束
+	cancel context.CancelFunc
+	list   []T
+
+	partial bool // box 超出 maxTry 时, 记录到 failed 中, 不影响其他的 box
+
+	completed int // 已完成的元素数
+	lost      int // 超出 maxTry 的元素数
+
+	splits  int          // 剩余可拆分的次数, 见 SetAdaptiveSplit
+	idx     int          // 下一个 box 的起始位置
+	pending int          // 已放入 inputs, 尚未从 outputs 取回的 box 数
+	retry   []*box[T, R] // 等待重试的 box
+
+	// 已完成, 尚未被 Get 取走的结果, 每次放入后通知 notify
+	mutex  sync.Mutex
+	chunks []Chunk[R]
+	notify chan struct{}
+
+	failed   []FailedRange
+	err      error
+	finished bool
+	done     chan struct{}
+}
+
+func newCall[T any, R any](pool, ctx context.Context, list []T, splits int) *call[T, R] {
+	c := &call[T, R]{
+		pool:   pool,
+		list:   list,
+		splits: splits,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(pool, cancel)
+	c.ctx = ctx
+	c.cancel = func() {
+		stop()
+		cancel()
+	}
+	return c
+}
+
+func (c *call[T, R]) deliver(chunk Chunk[R]) {
+	c.mutex.Lock()
+	c.chunks = append(c.chunks, chunk)
+	c.mutex.Unlock()
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take 取走所有已完成的结果
+func (c *call[T, R]) take() []Chunk[R] {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	chunks := c.chunks
+	c.chunks = nil
+	return chunks
+}
+
+func (c *call[T, R]) finish(err error) {
+	c.finished = true
+	c.err = err
+	if err != nil {
+		// 通知 worker 跳过剩余的 box
+		c.cancel()
+	}
+	close(c.done)
+}
+
+/*
+schedule 是唯一读写 inputs, outputs 以及 call 状态的协程
+
+inputs 中的 box 总数不超过 workLength, 且由 router 分给未满的 worker, 所以放入 inputs 不会阻塞
+各个 call 轮流放入 box, 避免大的 Get 阻塞小的 Get
+*/
+func (d *Dragonfly[T, R]) schedule(ctx context.Context, register chan *call[T, R],
+	r *router[T, R], outputs chan *box[T, R], p policy) {
+	var calls []*call[T, R]
+	next := 0 // 下一个轮到的 call
+	inflight := 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		d.rounds.Add(1)
+		now := time.Now()
+		var throttled time.Time // 所有未满的 worker 都被限速时, 最早获得令牌的时间
+		for inflight < d.workLength {
+			index, wake := r.choose(now)
+			if index < 0 {
+				throttled = wake
+				break
+			}
+			item := d.pick(calls, &next, now)
+			if item == nil {
+				break
+			}
+			r.assign(index, item)
+			inflight++
+		}
+		calls = slices.DeleteFunc(calls, func(c *call[T, R]) bool {
+			if c.finished && c.pending == 0 {
+				d.metrics.removeCall(len(c.list), c.completed, c.lost)
+				return true
+			}
+			return false
+		})
+
+		/*
+			有等待重试的 box 时, 在最早的时间醒来
+			worker 被限速时, 在获得令牌之前无法分发任何 box, 此时即便已有到时间的重试, 也等到获得令牌
+		*/
+		var wake <-chan time.Time
+		due, ok := earliestRetry(calls)
+		if !throttled.IsZero() {
+			due, ok = throttled, true
+		}
+		if ok && inflight < d.workLength {
+			timer.Reset(time.Until(due))
+			wake = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-register:
+			calls = append(calls, c)
+			d.metrics.addCall(len(c.list))
+		case item := <-outputs:
+			inflight--
+			r.release(item)
+			d.receive(item, p)
+			if p.progress != nil {
+				p.progress(d.metrics.snapshot())
+			}
+		case <-wake:
+		}
+	}
+}
+
+func earliestRetry[T any, R any](calls []*call[T, R]) (due time.Time, ok bool) {
+	for _, c := range calls {
+		if c.finished {
+			continue
+		}
+		for _, item := range c.retry {
+			if !ok || item.due.Before(due) {
+				due, ok = item.due, true
+			}
+		}
+	}
+	return
+}
+
+// pick 从 next 开始, 找到第一个还有 box 需要处理的 call, 优先重试已到时间的失败的 box
+func (d *Dragonfly[T, R]) pick(calls []*call[T, R], next *int, now time.Time) *box[T, R] {
+	for i := range calls {
+		index := (*next + i) % len(calls)
+		c := calls[index]
+		if c.finished {
+			continue
+		}
+		if c.ctx.Err() != nil {
+			// Get 已经返回
+			c.finish(c.ctx.Err())
+			continue
+		}
+		var item *box[T, R]
+		ready := slices.IndexFunc(c.retry, func(one *box[T, R]) bool {
+			return !one.due.After(now)
+		})
+		switch {
+		case ready >= 0:
+			item = c.retry[ready]
+			c.retry = slices.Delete(c.retry, ready, ready+1)
+		case c.idx < len(c.list):
+			j := min(c.idx+d.split, len(c.list))
+			item = &box[T, R]{
+				ctx:   c.ctx,
+				call:  c,
+				count: 1,
+				start: c.idx,
+				end:   j,
+				total: len(c.list),
+				In:    c.list[c.idx:j],
+			}
+			c.idx = j
+		default:
+			continue
+		}
+		c.pending++
+		*next = (index + 1) % len(calls)
+		return item
+	}
+	return nil
+}
+
+// policy 重试策略, 在 Start 时确定
+type policy struct {
+	backoff  Backoff
+	adaptive bool
+	progress func(Stats)
+}
+
+// bisect 将 box 拆分为两半, 尝试次数重新计算
+func bisect[T any, R any](item *box[T, R]) []*box[T, R] {
+	mid := len(item.In) / 2
+	return []*box[T, R]{
+		{
+			ctx:   item.ctx,
+			call:  item.call,
+			count: 1,
+			start: item.start,
+			end:   item.start + mid,
+			total: item.total,
+			In:    item.In[:mid],
+		},
+		{
+			ctx:   item.ctx,
+			call:  item.call,
+			count: 1,
+			start: item.start + mid,
+			end:   item.end,
+			total: item.total,
+			In:    item.In[mid:],
+		},
+	}
+}
+
+// receive 处理 worker 返回的 box
+func (d *Dragonfly[T, R]) receive(item *box[T, R], p policy) {
+	c := item.call
+	c.pending--
+	if c.finished {
+		// 已经失败, 只需要等待已分发的 box 全部返回
+		return
+	}
+
+	if c.ctx.Err() != nil {
+		// Get 已经返回
+		c.finish(c.ctx.Err())
+		return
+	}
+
+	item.count++
+	if item.Err != nil {
+		log.Entry.WithError(item.Err).Error(item.Err)
+		due := time.Now()
+		if p.backoff != nil {
+			due = due.Add(p.backoff(item.count - 1))
+		}
+		switch {
+		case p.adaptive && len(item.In) > 1 && item.count > min(2, d.maxTry) && c.splits > 0:
+			// 同一个 box 多次失败才拆分, 避免超时等偶发错误, 或节点不可用时拆分出大量的请求
+			c.splits--
+			d.metrics.retry()
+			for _, half := range bisect(item) {
+				half.due = due
+				c.retry = append(c.retry, half)
+			}
+			return
+		case item.count <= d.maxTry:
+			d.metrics.retry()
+			item.due = due
+			c.retry = append(c.retry, item)
+			return
+		case !c.partial:
+			c.finish(item.Err)
+			return
+		}
+		c.lost += len(item.In)
+		d.metrics.fail(len(item.In))
+		c.failed = append(c.failed, FailedRange{
+			Start: item.start,
+			End:   item.end,
+			Tries: item.count - 1,
+			Err:   item.Err,
+		})
+	} else {
+		c.completed += len(item.In)
+		c.splits++
+		d.metrics.complete(len(item.In), item.cost)
+		c.deliver(Chunk[R]{Start: item.start, End: item.end, Results: item.Result})
+	}
+	if c.idx >= len(c.list) && len(c.retry) == 0 && c.pending == 0 {
+		c.finish(nil)
+	}
+}
@@ -0,0 +1,159 @@
+package dragonfly
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencyWindow 计算分位数时, 使用最近完成的 box 的数量
+const latencyWindow = 1024
+
+/*
+Stats Dragonfly 某一时刻的运行状态
+
+Total, Completed, Failed 只统计进行中的 Get, Get 结束后不再计入
+Retries, Chunks 为 NewDragonfly 之后的累计值
+*/
+type Stats struct {
+	Calls     int // 进行中的 Get 数
+	Total     int // 元素总数
+	Completed int // 已完成的元素数
+	Failed    int // 超出 maxTry 的元素数(GetPartial)
+
+	Retries int64 // 重试的 box 数
+	Chunks  int64 // 完成的 box 数
+
+	// 最近完成的 box 的耗时(由 worker 统计, 不包括排队的时间)
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+
+	Rate float64       // 每秒完成的元素数, 从最近一次没有 Get 到有 Get 开始计算
+	ETA  time.Duration // 按 Rate 估计的剩余时间, Rate 为 0 时为 0
+}
+
+// Remaining 尚未完成的元素数
+func (s Stats) Remaining() int {
+	return s.Total - s.Completed - s.Failed
+}
+
+// metrics 由 schedule 协程更新, Stats 读取
+type metrics struct {
+	mutex sync.Mutex
+
+	calls     int
+	total     int
+	completed int
+	failed    int
+	retries   int64
+	chunks    int64
+
+	costs []time.Duration // 环形缓冲区
+	next  int
+
+	activeSince time.Time // calls 从 0 变为 1 的时间
+	activeDone  int       // activeSince 之后完成的元素数
+}
+
+func (m *metrics) addCall(total int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.calls == 0 {
+		m.activeSince = time.Now()
+		m.activeDone = 0
+	}
+	m.calls++
+	m.total += total
+}
+
+func (m *metrics) removeCall(total, completed, failed int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.calls--
+	m.total -= total
+	m.completed -= completed
+	m.failed -= failed
+}
+
+// reset 在 Close 时清除进行中的 Get
+func (m *metrics) reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.calls, m.total, m.completed, m.failed = 0, 0, 0, 0
+}
+
+func (m *metrics) complete(n int, cost time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.completed += n
+	m.activeDone += n
+	m.chunks++
+	if cost <= 0 {
+		return
+	}
+	if len(m.costs) < latencyWindow {
+		m.costs = append(m.costs, cost)
+		return
+	}
+	m.costs[m.next] = cost
+	m.next = (m.next + 1) % latencyWindow
+}
+
+func (m *metrics) fail(n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.failed += n
+}
+
+func (m *metrics) retry() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.retries++
+}
+
+func (m *metrics) snapshot() Stats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := Stats{
+		Calls:     m.calls,
+		Total:     m.total,
+		Completed: m.completed,
+		Failed:    m.failed,
+		Retries:   m.retries,
+		Chunks:    m.chunks,
+	}
+	if len(m.costs) > 0 {
+		costs := slices.Clone(m.costs)
+		slices.Sort(costs)
+		percentile := func(p float64) time.Duration {
+			return costs[int(p*float64(len(costs)-1))]
+		}
+		stats.P50, stats.P90, stats.P99 = percentile(0.5), percentile(0.9), percentile(0.99)
+	}
+	if m.calls > 0 {
+		if elapsed := time.Since(m.activeSince); elapsed > 0 {
+			stats.Rate = float64(m.activeDone) / elapsed.Seconds()
+		}
+		if stats.Rate > 0 {
+			stats.ETA = time.Duration(float64(stats.Remaining()) / stats.Rate * float64(time.Second))
+		}
+	}
+	return stats
+}
+
+// Stats 返回当前运行状态的快照
+func (d *Dragonfly[T, R]) Stats() Stats {
+	return d.metrics.snapshot()
+}
+
+/*
+SetProgress 每个 box 完成(包括失败)后调用 progress, 在 schedule 协程中同步调用, 需要尽快返回
+在下一次 Start 时生效
+*/
+func (d *Dragonfly[T, R]) SetProgress(progress func(Stats)) *Dragonfly[T, R] {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+	d.progress = progress
+	return d
+}
@@ -0,0 +1,170 @@
+package dragonfly
+
+import (
+	"context"
+	"iter"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Stream 从 input 中读取元素, 每 window 个作为一批提交, 按输入的顺序返回每个 box 的结果
+Chunk 的 Start, End 为在整个 input 中的位置
+
+input 在独立的协程中读取, 读取中及处理中的元素总数不超过 2 * window, 内存占用与 input 的总量无关
+没有处理中的批次时, 不等待凑满 window, 已读取的元素直接作为一批提交,
+所以 input 较慢, 或者需要等待结果才能继续产生时, 已完成的结果不会被推迟
+提前结束时, input 在下一次 yield 时停止, Stream 等待 input 返回后才返回, 之后可以安全的释放 input 的资源
+
+window <= 0 时, 为 (concurrent + redundancy) * split
+重试及并发与 Get 相同, 出错时返回一次 error 后结束
+*/
+func (d *Dragonfly[T, R]) Stream(ctx context.Context, input iter.Seq[T], window int) iter.Seq2[Chunk[R], error] {
+	return d.stream(ctx, func(context.Context) iter.Seq[T] {
+		return input
+	}, window)
+}
+
+// stream input 以 Stream 内部的 ctx 创建, 该 ctx 在 Stream 结束时取消
+func (d *Dragonfly[T, R]) stream(ctx context.Context, input func(context.Context) iter.Seq[T],
+	window int) iter.Seq2[Chunk[R], error] {
+	if window <= 0 {
+		window = d.workLength * d.split
+	}
+	return func(yield func(Chunk[R], error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		items := make(chan T)
+		reading := make(chan struct{})
+		defer func() {
+			// 等待 input 返回
+			cancel()
+			<-reading
+		}()
+		go func() {
+			defer close(reading)
+			defer close(items)
+			for item := range input(ctx) {
+				select {
+				case items <- item:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		type batch struct {
+			offset int
+			size   int
+			c      *call[T, R]
+		}
+		var batches []batch
+		defer func() {
+			for _, one := range batches {
+				one.c.cancel()
+			}
+		}()
+		var pending []T // 已读取, 尚未提交的元素
+		held := 0       // 已提交, 尚未返回的元素数
+		offset := 0
+		closed := false
+		for {
+			if len(batches) == 0 && !closed {
+				// 只读取已经就绪的元素
+				closed = collect(items, &pending, window)
+			}
+			if len(pending) > 0 && (len(pending) == window || closed || len(batches) == 0) {
+				c, err := d.submit(ctx, pending, false)
+				if err != nil {
+					yield(Chunk[R]{}, err)
+					return
+				}
+				batches = append(batches, batch{offset: offset, size: len(pending), c: c})
+				offset += len(pending)
+				held += len(pending)
+				pending = nil
+			}
+
+			var recv <-chan T
+			if !closed && held+len(pending) < 2*window {
+				recv = items
+			}
+			var done, pool <-chan struct{}
+			if len(batches) > 0 {
+				done, pool = batches[0].c.done, batches[0].c.pool.Done()
+			} else if recv == nil {
+				return
+			}
+
+			select {
+			case item, ok := <-recv:
+				if !ok {
+					closed = true
+					continue
+				}
+				pending = append(pending, item)
+			case <-done:
+				head := batches[0]
+				if head.c.err != nil {
+					yield(Chunk[R]{}, head.c.err)
+					return
+				}
+				batches = batches[1:]
+				held -= head.size
+				head.c.cancel()
+
+				chunks := head.c.take()
+				sort.Slice(chunks, func(i, j int) bool {
+					return chunks[i].Start < chunks[j].Start
+				})
+				for _, chunk := range chunks {
+					chunk.Start += head.offset
+					chunk.End += head.offset
+					if !yield(chunk, nil) {
+						return
+					}
+				}
+			case <-pool:
+				yield(Chunk[R]{}, ErrClosed)
+				return
+			case <-ctx.Done():
+				yield(Chunk[R]{}, errors.WithStack(ctx.Err()))
+				return
+			}
+		}
+	}
+}
+
+// collect 在不阻塞的情况下, 从 items 中读取元素直至 pending 有 window 个, items 被关闭时返回 true
+func collect[T any](items <-chan T, pending *[]T, window int) bool {
+	for len(*pending) < window {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				return true
+			}
+			*pending = append(*pending, item)
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// StreamChan 同 Stream, 从 channel 中读取, 直至 channel 被关闭
+func (d *Dragonfly[T, R]) StreamChan(ctx context.Context, input <-chan T, window int) iter.Seq2[Chunk[R], error] {
+	return d.stream(ctx, func(ctx context.Context) iter.Seq[T] {
+		return func(yield func(T) bool) {
+			for {
+				select {
+				case item, ok := <-input:
+					if !ok || !yield(item) {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}, window)
+}
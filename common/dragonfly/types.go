@@ -2,23 +2,48 @@ package dragonfly
 
 import (
 	"context"
-	"reflect"
-	"runtime"
-	"strings"
+	"iter"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/LukeEuler/dolly/common/internal/worker"
 	"github.com/LukeEuler/dolly/common/tree"
 	"github.com/LukeEuler/dolly/log"
 )
 
 type Factory[T any, R any] func() (Worker[T, R], error)
 
+/*
+Worker 用于处理具体业务对接口方法
+
+worker 在多次 Get 之间复用, ctx 结束后需要尽快退出
+box 的 ctx 结束时, 不需要再处理, 直接放回 out 即可
+*/
 type Worker[T any, R any] func(ctx context.Context, in chan *box[T, R], out chan *box[T, R])
 
+// Backoff 返回 box 第 tries 次失败后, 重新放入 inputs 前的等待时间
+type Backoff func(tries int) time.Duration
+
+func ConstantBackoff(wait time.Duration) Backoff {
+	return func(int) time.Duration {
+		return wait
+	}
+}
+
+// ExponentialBackoff base, 2*base, 4*base ... 直至 maxWait
+func ExponentialBackoff(base, maxWait time.Duration) Backoff {
+	return worker.ExponentialBackoff(base, maxWait)
+}
+
 type box[T any, R any] struct {
+	ctx    context.Context // 所属 Get 的 ctx, 结束后 worker 不再处理, 直接返回
+	call   *call[T, R]
+	worker int // 分到的 worker, 见 router
 	count  int
+	due    time.Time     // 重试时, 放入 inputs 的最早时间
+	cost   time.Duration // worker 处理耗时, 未统计时为 0
 	start  int
 	end    int
 	total  int
@@ -33,20 +58,52 @@ type box[T any, R any] struct {
 针对批量任务, 提供一个批处理接口
 */
 type IDragonfly[T any, R any] interface {
-	Get([]T) ([]R, error)
+	Get([]T) ([]R, error)                         // 结果按输入的顺序排列
+	GetContext(context.Context, []T) ([]R, error) // 同 Get, ctx 结束时返回
+	Unordered([]T) iter.Seq2[Chunk[R], error]     // 按完成的顺序返回每个 box 的结果
+	GetPartial([]T) ([]Chunk[R], error)           // 返回成功的结果, 以及失败的范围(*PartialError)
+
+	// 分批读取 input, 按输入的顺序返回结果, 内存占用与 input 的总量无关
+	Stream(ctx context.Context, input iter.Seq[T], window int) iter.Seq2[Chunk[R], error]
 }
 
 func NewWorkerFactory[T any, R any](
 	nextClient func() (tree.Context, error),
 	f func(tree.Context, []T) ([]R, error)) Factory[T, R] {
-	funcName := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
-	list := strings.Split(funcName, "/")
-	if len(list) > 0 {
-		funcName = list[len(list)-1]
-	}
+	return NewWorkerFactoryWithHealth(nextClient, f, Health{})
+}
 
+// NewWorkerFactoryWithHealth 同 NewWorkerFactory, worker 连续失败过多时, 通过 nextClient 替换 client
+func NewWorkerFactoryWithHealth[T any, R any](
+	nextClient func() (tree.Context, error),
+	f func(tree.Context, []T) ([]R, error),
+	health Health) Factory[T, R] {
+	return newWorkerFactory(nextClient, worker.FuncName(f),
+		func(_ context.Context, client tree.Context, in []T) ([]R, error) {
+			return f(client, in)
+		}, health)
+}
+
+/*
+NewContextWorkerFactory 同 NewWorkerFactoryWithHealth, f 可以通过 ctx 中断进行中的请求
+ctx 在 Get 结束时取消, 包括 GetContext 的 ctx 结束, 以及其他 box 超出 maxTry 导致 Get 失败
+ctx 取消导致的错误不计入 Health 的连续失败次数
+*/
+func NewContextWorkerFactory[T any, R any](
+	nextClient func() (tree.Context, error),
+	f func(context.Context, tree.Context, []T) ([]R, error),
+	health Health) Factory[T, R] {
+	return newWorkerFactory(nextClient, worker.FuncName(f), f, health)
+}
+
+func newWorkerFactory[T any, R any](
+	nextClient func() (tree.Context, error),
+	funcName string,
+	f func(context.Context, tree.Context, []T) ([]R, error),
+	health Health) Factory[T, R] {
+	var counter atomic.Int64
 	return func() (Worker[T, R], error) {
-		tctx, err := nextClient()
+		hc, err := worker.NewHealthClient("dragonfly", &counter, nextClient, health)
 		if err != nil {
 			return nil, err
 		}
@@ -60,16 +117,32 @@ func NewWorkerFactory[T any, R any](
 						// inputs 被关闭了, worker 就需要停下
 						return
 					}
+					if item.ctx != nil && item.ctx.Err() != nil {
+						item.Err = item.ctx.Err()
+						select {
+						case outputs <- item:
+						case <-ctx.Done():
+							return
+						}
+						continue
+					}
+					itemCtx := item.ctx
+					if itemCtx == nil {
+						itemCtx = ctx
+					}
 					start := time.Now()
-					item.Result, item.Err = f(tctx, item.In)
+					item.Result, item.Err = f(itemCtx, hc.Client(), item.In)
+					item.cost = time.Since(start)
+					if itemCtx.Err() == nil {
+						hc.Report(item.Err)
+					}
 					log.Entry.
 						WithField("dragonfly", funcName).
-						WithField("cost", time.Since(start).String()).
+						WithField("cost", item.cost.String()).
 						Infof("%d~%d %d", item.start, item.end, item.total)
 					if item.Err != nil {
+						// 重试前的等待由 Dragonfly 的 Backoff 控制
 						item.Err = errors.Wrapf(item.Err, "%d~%d %d", item.start, item.end, item.total)
-						// 防止程序在错误上，过多的浪费资源。主要是错误日志会爆
-						time.Sleep(time.Second)
 					}
 
 					select {
@@ -29,8 +29,9 @@ func bashEscape(str string) string {
 	return `'` + strings.Replace(str, `'`, `'\''`, -1) + `'`
 }
 
-// GetCurlCommand returns a CurlCommand corresponding to an http.Request
-func GetCurlCommand(req *http.Request) (*CurlCommand, error) {
+// GetCurlCommand returns a CurlCommand corresponding to an http.Request,
+// the values of the redact headers are replaced with "***"
+func GetCurlCommand(req *http.Request, redact ...string) (*CurlCommand, error) {
 	if req.URL == nil {
 		return nil, fmt.Errorf("getCurlCommand: invalid request, req.URL is nil")
 	}
@@ -76,8 +77,17 @@ func GetCurlCommand(req *http.Request) (*CurlCommand, error) {
 	}
 	sort.Strings(keys)
 
+	hidden := make(map[string]bool, len(redact))
+	for _, k := range redact {
+		hidden[http.CanonicalHeaderKey(k)] = true
+	}
+
 	for _, k := range keys {
-		command.append("-H", bashEscape(fmt.Sprintf("%s: %s", k, strings.Join(req.Header[k], " "))))
+		value := strings.Join(req.Header[k], " ")
+		if hidden[http.CanonicalHeaderKey(k)] {
+			value = "***"
+		}
+		command.append("-H", bashEscape(fmt.Sprintf("%s: %s", k, value)))
 	}
 
 	command.append(bashEscape(requestURL))
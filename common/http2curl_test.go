@@ -142,6 +142,19 @@ func ExampleGetCurlCommand_https() {
 	// Output: curl -k -X 'PUT' -d '{"hello":"world","answer":42}' -H 'Content-Type: application/json' -H 'X-Auth-Token: private-token' 'https://www.example.com/abc/def.ghi?jlk=mno&pqr=stu' --compressed
 }
 
+func ExampleGetCurlCommand_redact() {
+	req, _ := http.NewRequest("GET", "http://www.example.com/abc/def.ghi?jlk=mno&pqr=stu", nil)
+	req.Header.Set("X-Api-Key", "private-key")
+	req.Header.Set("X-Signature", "private-signature")
+	req.Header.Set("X-Timestamp", "1700000000000")
+
+	command, _ := GetCurlCommand(req, "x-api-key", "X-Signature")
+	fmt.Println(command)
+
+	// Output:
+	// curl -X 'GET' -H 'X-Api-Key: ***' -H 'X-Signature: ***' -H 'X-Timestamp: 1700000000000' 'http://www.example.com/abc/def.ghi?jlk=mno&pqr=stu' --compressed
+}
+
 // Benchmark test for GetCurlCommand
 func BenchmarkGetCurlCommand(b *testing.B) {
 	form := url.Values{}
@@ -0,0 +1,14 @@
+package worker
+
+import "time"
+
+// ExponentialBackoff base, 2*base, 4*base ... 直至 maxWait
+func ExponentialBackoff(base, maxWait time.Duration) func(tries int) time.Duration {
+	return func(tries int) time.Duration {
+		wait := base
+		for i := 1; i < tries && wait < maxWait; i++ {
+			wait *= 2
+		}
+		return min(wait, maxWait)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Package worker tentacle 与 dragonfly 的 worker 共用的部分
+*/
+package worker
+
+import (
+	"sync/atomic"
+
+	"github.com/LukeEuler/dolly/common/tree"
+	"github.com/LukeEuler/dolly/log"
+)
+
+/*
+Health worker 级别的健康检查
+
+worker 连续失败 MaxFailures 次后, 丢弃当前的 client, 通过 nextClient 重新创建
+重新创建失败时, 继续使用原来的 client, 下一次失败时再次尝试
+*/
+type Health struct {
+	MaxFailures int                      // <= 0 时不检查
+	OnReplace   func(event ReplaceEvent) // 可选, 在 worker 的协程中调用
+}
+
+// ReplaceEvent client 被替换(或替换失败)的事件
+type ReplaceEvent struct {
+	Worker   int64 // 由同一个 Factory 创建的 worker 的编号, 从 1 开始
+	Failures int   // 连续失败的次数
+	Err      error // 最后一次的错误
+	NewErr   error // nextClient 的错误, 为 nil 表示替换成功
+}
+
+// HealthClient worker 持有的 client, 只在 worker 的协程中使用
+type HealthClient struct {
+	name     string // 日志中的字段名
+	id       int64
+	client   tree.Context
+	next     func() (tree.Context, error)
+	health   Health
+	failures int
+}
+
+// NewHealthClient counter 由同一个 Factory 创建的 worker 共用, 用于编号
+func NewHealthClient(name string, counter *atomic.Int64, next func() (tree.Context, error),
+	health Health) (*HealthClient, error) {
+	client, err := next()
+	if err != nil {
+		return nil, err
+	}
+	return &HealthClient{
+		name:   name,
+		id:     counter.Add(1),
+		client: client,
+		next:   next,
+		health: health,
+	}, nil
+}
+
+// Client 当前使用的 client
+func (h *HealthClient) Client() tree.Context {
+	return h.client
+}
+
+// Report 记录一次处理结果, 连续失败过多时替换 client
+func (h *HealthClient) Report(err error) {
+	if err == nil {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.health.MaxFailures <= 0 || h.failures < h.health.MaxFailures {
+		return
+	}
+
+	event := ReplaceEvent{
+		Worker:   h.id,
+		Failures: h.failures,
+		Err:      err,
+	}
+	client, newErr := h.next()
+	if newErr != nil {
+		event.NewErr = newErr
+		log.Entry.WithField(h.name, "health").WithError(newErr).
+			Errorf("worker %d replace client after %d failures", h.id, h.failures)
+	} else {
+		h.client = client
+		h.failures = 0
+		log.Entry.WithField(h.name, "health").
+			Warnf("worker %d client replaced after %d failures", h.id, event.Failures)
+	}
+	if h.health.OnReplace != nil {
+		h.health.OnReplace(event)
+	}
+}
@@ -0,0 +1,17 @@
+package worker
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// FuncName f 的函数名, 不包含包路径, 用于日志
+func FuncName(f any) string {
+	funcName := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	list := strings.Split(funcName, "/")
+	if len(list) > 0 {
+		funcName = list[len(list)-1]
+	}
+	return funcName
+}
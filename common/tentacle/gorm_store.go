@@ -0,0 +1,105 @@
+package tentacle
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StoreRecord GormStore 的表结构，scope 用于多个 Tentacle 共用一张表
+type StoreRecord struct {
+	Scope    string `gorm:"primaryKey;size:64"`
+	Sequence int64  `gorm:"primaryKey;autoIncrement:false"`
+	Data     []byte `gorm:"type:mediumblob"`
+}
+
+/*
+GormStore 将结果以 json 的形式保存在数据库中(MySQL)
+T 需要能够被 encoding/json 正确的序列化
+
+example:
+
+	store := tentacle.NewGormStore[*Block](db, "tentacle_blocks", "eth")
+	err := store.Migrate()
+	...
+	t := tentacle.NewTentacle(4, 2, 6, factory).SetStore(store)
+*/
+type GormStore[T any] struct {
+	db    *gorm.DB
+	table string
+	scope string
+}
+
+func NewGormStore[T any](db *gorm.DB, table, scope string) *GormStore[T] {
+	return &GormStore[T]{
+		db:    db,
+		table: table,
+		scope: scope,
+	}
+}
+
+// Migrate 创建或更新表结构
+func (s *GormStore[T]) Migrate() error {
+	return errors.WithStack(s.db.Table(s.table).AutoMigrate(&StoreRecord{}))
+}
+
+func (s *GormStore[T]) LoadFrom(fromSequence int64) (map[int64]T, error) {
+	var records []StoreRecord
+	err := s.db.Table(s.table).
+		Where("scope = ? AND sequence >= ?", s.scope, fromSequence).
+		Find(&records).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	values := make(map[int64]T, len(records))
+	for _, record := range records {
+		var value T
+		err = json.Unmarshal(record.Data, &value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode sequence %d", record.Sequence)
+		}
+		values[record.Sequence] = value
+	}
+	return values, nil
+}
+
+func (s *GormStore[T]) Save(values map[int64]T) error {
+	if len(values) == 0 {
+		return nil
+	}
+	records := make([]*StoreRecord, 0, len(values))
+	for sequence, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return errors.Wrapf(err, "encode sequence %d", sequence)
+		}
+		records = append(records, &StoreRecord{
+			Scope:    s.scope,
+			Sequence: sequence,
+			Data:     data,
+		})
+	}
+	err := s.db.Table(s.table).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "scope"}, {Name: "sequence"}},
+			DoUpdates: clause.AssignmentColumns([]string{"data"}),
+		}).
+		Create(records).Error
+	return errors.WithStack(err)
+}
+
+func (s *GormStore[T]) Prune(beforeSequence int64) error {
+	err := s.db.Table(s.table).
+		Where("scope = ? AND sequence < ?", s.scope, beforeSequence).
+		Delete(&StoreRecord{}).Error
+	return errors.WithStack(err)
+}
+
+func (s *GormStore[T]) Invalidate(fromSequence int64) error {
+	err := s.db.Table(s.table).
+		Where("scope = ? AND sequence >= ?", s.scope, fromSequence).
+		Delete(&StoreRecord{}).Error
+	return errors.WithStack(err)
+}
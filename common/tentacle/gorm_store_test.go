@@ -0,0 +1,53 @@
+package tentacle
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func testNewGormStore(t *testing.T, scope string, db *gorm.DB) *GormStore[mint64] {
+	store := NewGormStore[mint64](db, "tentacle_store", scope)
+	assert.NoError(t, store.Migrate())
+	return store
+}
+
+func TestGormStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "store.db")), &gorm.Config{})
+	assert.NoError(t, err)
+	store := testNewGormStore(t, "a", db)
+	other := testNewGormStore(t, "b", db)
+
+	assert.NoError(t, store.Save(nil))
+	assert.NoError(t, store.Save(map[int64]mint64{1: 11, 2: 12, 3: 13}))
+	assert.NoError(t, other.Save(map[int64]mint64{1: 21, 5: 25}))
+
+	values, err := store.LoadFrom(2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]mint64{2: 12, 3: 13}, values)
+
+	// 同一个 sequence 再次保存时覆盖
+	assert.NoError(t, store.Save(map[int64]mint64{3: 33, 4: 14, 5: 15}))
+	values, err = store.LoadFrom(0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]mint64{1: 11, 2: 12, 3: 33, 4: 14, 5: 15}, values)
+
+	assert.NoError(t, store.Prune(3))
+	values, err = store.LoadFrom(0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]mint64{3: 33, 4: 14, 5: 15}, values)
+
+	// Rewind 时删除之后的结果
+	assert.NoError(t, store.Invalidate(4))
+	values, err = store.LoadFrom(0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]mint64{3: 33}, values)
+
+	// 不影响其他 scope
+	values, err = other.LoadFrom(0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]mint64{1: 21, 5: 25}, values)
+}
@@ -0,0 +1,14 @@
+package tentacle
+
+import "github.com/LukeEuler/dolly/common/internal/worker"
+
+/*
+Health worker 级别的健康检查
+
+worker 连续失败 MaxFailures 次后，丢弃当前的 client，通过 nextClient 重新创建
+重新创建失败时，继续使用原来的 client，下一次失败时再次尝试
+*/
+type Health = worker.Health
+
+// ReplaceEvent client 被替换(或替换失败)的事件
+type ReplaceEvent = worker.ReplaceEvent
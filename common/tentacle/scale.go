@@ -0,0 +1,193 @@
+package tentacle
+
+import (
+	"context"
+	"time"
+
+	"github.com/LukeEuler/dolly/log"
+)
+
+/*
+AutoScale 根据 queue 的积压及 worker 的耗时自动调整 worker 数
+
+每个 Interval 检查一次:
+  - inputs 中没有待处理的任务(已追上 maxSequence)，或者 queue 积压超过一半(消费方跟不上)，减少一个 worker
+  - MaxLatency > 0 且 worker 耗时超过 MaxLatency(后端过载)，减少一个 worker
+  - 其余情况(还有待处理的任务，且消费方在等待结果)，增加一个 worker
+*/
+type AutoScale struct {
+	Min        int64         // worker 数下限，最小为 1
+	Max        int64         // worker 数上限，最大为 concurrent * redundancy
+	Interval   time.Duration // 检查间隔，默认 1s
+	MaxLatency time.Duration // 0 表示不检查耗时
+}
+
+// Concurrency 当前期望的 worker 数
+func (t *Tentacle[T]) Concurrency() int64 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.scale
+}
+
+/*
+SetConcurrency 运行中调整 worker 数，n 被限制在 [1, concurrent * redundancy]
+增加时通过 Factory 创建新的 worker，减少时通知最后加入的 worker 在手头的任务结束后退出
+未开始工作时，仅记录下来，在开始工作时生效
+*/
+func (t *Tentacle[T]) SetConcurrency(n int64) error {
+	t.mutex.Lock()
+	t.scale = min(max(n, 1), t.workLength)
+	started, ctx := t.cursor.workStarted, t.ctx
+	t.mutex.Unlock()
+	if !started || ctx.Err() != nil {
+		return nil
+	}
+	return t.applyScale(ctx)
+}
+
+/*
+SetAutoScale 开启自动伸缩，nil 表示关闭
+在运行中设置时立即生效
+*/
+func (t *Tentacle[T]) SetAutoScale(cfg *AutoScale) *Tentacle[T] {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if cfg != nil {
+		c := *cfg
+		c.Min = min(max(c.Min, 1), t.workLength)
+		c.Max = min(max(c.Max, c.Min), t.workLength)
+		if c.Interval <= 0 {
+			c.Interval = time.Second
+		}
+		cfg = &c
+	}
+	t.autoScale = cfg
+	if cfg != nil && t.cursor.workStarted && t.ctx.Err() == nil {
+		t.runAutoScaleLocked(cfg)
+	}
+	return t
+}
+
+/*
+applyScale 使运行中的 worker 数与 scale 一致
+新的 worker 在锁外通过 Factory 创建，只在持有写锁时加入，避免 Factory 耗时期间阻塞 Get 等操作
+*/
+func (t *Tentacle[T]) applyScale(ctx context.Context) error {
+	t.mutex.Lock()
+	need := t.resizeLocked(ctx, nil)
+	t.mutex.Unlock()
+
+	workers := make([]Worker[T], 0, max(need, 0))
+	var err error
+	for range need {
+		var worker Worker[T]
+		worker, err = t.generate()
+		if err != nil {
+			break
+		}
+		workers = append(workers, worker)
+	}
+	if len(workers) > 0 {
+		t.mutex.Lock()
+		t.resizeLocked(ctx, workers)
+		t.mutex.Unlock()
+	}
+	return err
+}
+
+/*
+resizeLocked 需要持有写锁，用 workers 补足 scale，多出的 worker 从末尾开始退出，返回还需要创建的 worker 数
+ctx 已结束或者已经重新开始工作时不做任何处理，创建期间 scale 被调小时，多余的 workers 直接丢弃
+*/
+func (t *Tentacle[T]) resizeLocked(ctx context.Context, workers []Worker[T]) int64 {
+	if ctx != t.ctx || ctx.Err() != nil {
+		return 0
+	}
+	from := int64(len(t.workers))
+	for _, worker := range workers {
+		if int64(len(t.workers)) >= t.scale {
+			break
+		}
+		t.runWorkerLocked(worker)
+	}
+	for int64(len(t.workers)) > t.scale {
+		last := len(t.workers) - 1
+		t.workers[last]()
+		t.workers = t.workers[:last]
+	}
+	to := int64(len(t.workers))
+	if to != from && t.metrics != nil && t.metrics.OnScale != nil {
+		t.metrics.OnScale(from, to)
+	}
+	return t.scale - to
+}
+
+// runAutoScaleLocked 需要持有写锁，每个运行周期只有一个生效的自动伸缩协程
+func (t *Tentacle[T]) runAutoScaleLocked(cfg *AutoScale) {
+	ctx := t.ctx
+	running := t.running
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !t.autoScaleOnce(ctx, cfg) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// autoScaleOnce 返回 false 表示自动伸缩已被关闭或替换
+func (t *Tentacle[T]) autoScaleOnce(ctx context.Context, cfg *AutoScale) bool {
+	t.mutex.Lock()
+	if ctx.Err() != nil || t.autoScale != cfg {
+		t.mutex.Unlock()
+		return false
+	}
+	pending := len(t.inputs)
+	depth := int64(len(t.queue))
+
+	target := t.scale
+	switch {
+	case pending == 0 || depth*2 >= t.workLength:
+		target--
+	case cfg.MaxLatency > 0 && t.latency > cfg.MaxLatency:
+		target--
+	default:
+		target++
+	}
+	target = min(max(target, cfg.Min), cfg.Max)
+	if target == t.scale {
+		t.mutex.Unlock()
+		return true
+	}
+	log.Entry.WithField("tentacle", "scale").
+		WithField("pending", pending).
+		WithField("queue", depth).
+		WithField("latency", t.latency.String()).
+		Infof("workers %d -> %d", t.scale, target)
+	t.scale = target
+	t.mutex.Unlock()
+
+	err := t.applyScale(ctx)
+	if err != nil {
+		log.Entry.WithError(err).Error(err)
+	}
+	return true
+}
+
+// observeLatency 需要持有写锁
+func (t *Tentacle[T]) observeLatency(cost time.Duration) {
+	if t.latency == 0 {
+		t.latency = cost
+		return
+	}
+	t.latency += (cost - t.latency) / 5
+}
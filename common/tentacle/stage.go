@@ -0,0 +1,74 @@
+package tentacle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+Then 在 prev 的结果上串联一个并行处理阶段，返回新的 Factory
+
+	fetch(worker 数由 Tentacle 的 concurrent 决定) -> decode(concurrent 个协程) -> ... -> Get(顺序处理)
+
+同一个 Factory 创建的所有 worker 共享 concurrent 个处理名额，与 worker 数无关
+每个阶段都按 sequence 传递结果，最终由 Tentacle 排序，顺序保证与单阶段时一致
+任一阶段出错时，整个 sequence 按失败处理，重试时从第一个阶段重新开始
+
+example:
+
+	factory := tentacle.Then(tentacle.NewWorkerFactory(nextClient, fetchBlock), 8, decodeBlock)
+	t := tentacle.NewTentacle(4, 4, 6, factory)
+*/
+func Then[A Cloner[A], B Cloner[B]](prev Factory[A], concurrent int, f func(sequence int64, in A) (B, error)) Factory[B] {
+	slots := make(chan struct{}, max(concurrent, 1))
+	return func() (Worker[B], error) {
+		worker, err := prev()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, inputs chan int64, outputs chan *box[B]) {
+			// 上一阶段的结果通过 middle 逐个交接，上一阶段的 worker 退出后，不会再有结果
+			middle := make(chan *box[A])
+			exited := make(chan struct{})
+			go func() {
+				defer close(exited)
+				worker(ctx, inputs, middle)
+			}()
+
+			// 已领取的任务需要处理完，worker 才能退出
+			var pending sync.WaitGroup
+			defer pending.Wait()
+			for {
+				select {
+				case <-exited:
+					return
+				case item := <-middle:
+					slots <- struct{}{}
+					pending.Add(1)
+					go func() {
+						defer pending.Done()
+						res := applyStage(item, f)
+						<-slots
+						outputs <- res
+					}()
+				}
+			}
+		}, nil
+	}
+}
+
+func applyStage[A Cloner[A], B Cloner[B]](item *box[A], f func(int64, A) (B, error)) *box[B] {
+	res := &box[B]{
+		sequence: item.sequence,
+		err:      item.err,
+		cost:     item.cost,
+	}
+	if item.err != nil {
+		return res
+	}
+	start := time.Now()
+	res.result, res.err = f(item.sequence, item.result)
+	res.cost += time.Since(start)
+	return res
+}
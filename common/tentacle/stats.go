@@ -0,0 +1,143 @@
+package tentacle
+
+import (
+	"sort"
+	"time"
+)
+
+// Stats Tentacle 某一时刻的运行状态
+type Stats struct {
+	Started     bool
+	Concurrency int64 // 期望的 worker 数
+	Workers     int64 // 运行中的 worker 数
+
+	MaxSequence        int64
+	LastInputsSequence int64 // 已分发的最大 sequence，与 MaxSequence 的差距即为尚未分发的任务数
+	LastQueueSequence  int64 // 已按顺序完成的最大 sequence
+	ReservedMin        int64 // 保留区为空时，ReservedMin > ReservedMax
+	ReservedMax        int64 // 已被消费的最大 sequence
+
+	Inputs     int // inputs 中等待 worker 领取的任务数
+	Outputs    int // outputs 中等待排序的结果数
+	Cached     int // cacheArea 中等待前序结果的数量
+	QueueDepth int // queue 中等待消费的结果数
+
+	Latency  time.Duration   // worker 处理单个 sequence 耗时的 EWMA
+	InFlight []SequenceStats // 已分发、尚未按顺序完成的 sequence，按 sequence 排序
+}
+
+// SequenceStats 单个 sequence 的处理状态
+type SequenceStats struct {
+	Sequence int64
+	Attempts int           // 已失败的次数
+	Age      time.Duration // 距离首次分发的时间
+	Paused   bool          // 被 FailurePause 挂起
+	Err      error         // 挂起时为最后一次的错误
+}
+
+// Lag 尚未分发的任务数，持续增大说明预取跟不上 maxSequence
+func (s Stats) Lag() int64 {
+	if !s.Started {
+		return 0
+	}
+	return s.MaxSequence - s.LastInputsSequence
+}
+
+// Prefetched 已按顺序完成、尚未被消费的数量
+func (s Stats) Prefetched() int64 {
+	if !s.Started {
+		return 0
+	}
+	return s.LastQueueSequence - s.ReservedMax
+}
+
+// Oldest 处理时间最长的 sequence，没有时 ok 为 false
+func (s Stats) Oldest() (item SequenceStats, ok bool) {
+	for _, one := range s.InFlight {
+		if !ok || one.Age > item.Age {
+			item, ok = one, true
+		}
+	}
+	return
+}
+
+/*
+Metrics 可选的指标回调，在持有 Tentacle 锁时同步调用
+回调需要尽快返回，且不能调用 Tentacle 的方法
+*/
+type Metrics struct {
+	// 每次收到 worker 的结果时调用，attempt 为本次是第几次尝试(从 1 开始)
+	OnResult func(sequence int64, cost time.Duration, attempt int, err error)
+	// sequence 按顺序完成(进入 queue)时调用，wait 为距离首次分发的时间，err 为最终的错误(FailureFail)
+	OnComplete func(sequence int64, wait time.Duration, err error)
+	// 运行中的 worker 数变化时调用
+	OnScale func(from, to int64)
+}
+
+// SetMetrics nil 表示关闭
+func (t *Tentacle[T]) SetMetrics(metrics *Metrics) *Tentacle[T] {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.metrics = metrics
+	return t
+}
+
+// Stats 返回当前运行状态的快照
+func (t *Tentacle[T]) Stats() Stats {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	stats := Stats{
+		Started:            t.cursor.workStarted,
+		Concurrency:        t.scale,
+		Workers:            int64(len(t.workers)),
+		MaxSequence:        t.cursor.maxSequence,
+		LastInputsSequence: t.cursor.lastInputsSequence,
+		LastQueueSequence:  t.cursor.lastQueueSequence,
+		ReservedMin:        t.cursor.reservedAreaMin,
+		ReservedMax:        t.cursor.reservedAreaMax,
+		Inputs:             len(t.inputs),
+		Outputs:            len(t.outputs),
+		Cached:             len(t.cacheArea),
+		QueueDepth:         len(t.queue),
+		Latency:            t.latency,
+		InFlight:           make([]SequenceStats, 0, len(t.dispatched)),
+	}
+	if t.cursor.reservedAreaEmpty {
+		stats.ReservedMin = stats.ReservedMax + 1
+	}
+
+	now := time.Now()
+	for sequence, since := range t.dispatched {
+		if _, ok := t.cacheArea[sequence]; ok {
+			continue
+		}
+		err, paused := t.paused[sequence]
+		stats.InFlight = append(stats.InFlight, SequenceStats{
+			Sequence: sequence,
+			Attempts: t.attempts[sequence],
+			Age:      now.Sub(since),
+			Paused:   paused,
+			Err:      err,
+		})
+	}
+	sort.Slice(stats.InFlight, func(i, j int) bool {
+		return stats.InFlight[i].Sequence < stats.InFlight[j].Sequence
+	})
+	return stats
+}
+
+// dispatch 需要持有写锁，记录 sequence 首次分发的时间，重试时不更新
+func (t *Tentacle[T]) dispatch(sequence int64) {
+	if _, ok := t.dispatched[sequence]; !ok {
+		t.dispatched[sequence] = time.Now()
+	}
+}
+
+// complete 需要持有写锁，sequence 已按顺序进入 queue
+func (t *Tentacle[T]) complete(item *box[T]) {
+	since, ok := t.dispatched[item.sequence]
+	delete(t.dispatched, item.sequence)
+	if ok && t.metrics != nil && t.metrics.OnComplete != nil {
+		t.metrics.OnComplete(item.sequence, time.Since(since), item.err)
+	}
+}
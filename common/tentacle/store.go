@@ -0,0 +1,338 @@
+package tentacle
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/LukeEuler/dolly/log"
+)
+
+/*
+Store 持久化已完成的结果，重启后 Get 可以直接使用，不需要再次运行 worker
+
+Store 的方法不会被并发调用，除 Save 外，Tentacle 在持有锁时同步调用，实现需要尽快返回
+  - 开始工作(Start 或 Stop 后第一次 Get)时 LoadFrom 一次，分发 sequence 时命中则直接作为结果
+  - worker 成功返回后，在独立的协程中批量 Save，不阻塞 Get；失败(包括 FailureSkip)的结果不会保存
+  - 结果移出保留区后 Prune，每移出 workLength 个调用一次
+  - Rewind(r) 时 Invalidate(r+1)，尚未保存的大于 r 的结果不再保存
+
+重启前如果发生了 reorg，需要在 Start 之前自行调用 Invalidate
+*/
+type Store[T any] interface {
+	LoadFrom(fromSequence int64) (map[int64]T, error) // 读取大于等于 fromSequence 的结果
+	Save(values map[int64]T) error                    // 保存(覆盖) sequence -> 结果
+	Prune(beforeSequence int64) error                 // 删除小于 beforeSequence 的结果
+	Invalidate(fromSequence int64) error              // 删除大于等于 fromSequence 的结果
+}
+
+/*
+FileStore 每个 sequence 保存为 dir 下的一个 json 文件，文件名为 <sequence>.json
+T 需要能够被 encoding/json 正确的序列化
+*/
+type FileStore[T any] struct {
+	dir string
+}
+
+func NewFileStore[T any](dir string) (*FileStore[T], error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &FileStore[T]{dir: dir}, nil
+}
+
+func (s *FileStore[T]) path(sequence int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(sequence, 10)+".json")
+}
+
+func (s *FileStore[T]) LoadFrom(fromSequence int64) (map[int64]T, error) {
+	sequences, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[int64]T)
+	for _, sequence := range sequences {
+		if sequence < fromSequence {
+			continue
+		}
+		data, err := os.ReadFile(s.path(sequence))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var value T
+		err = json.Unmarshal(data, &value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode sequence %d", sequence)
+		}
+		values[sequence] = value
+	}
+	return values, nil
+}
+
+func (s *FileStore[T]) Save(values map[int64]T) error {
+	for sequence, value := range values {
+		err := s.save(sequence, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save 先写入临时文件再重命名，避免进程中断时留下不完整的文件
+func (s *FileStore[T]) save(sequence int64, value T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "encode sequence %d", sequence)
+	}
+	tmp := s.path(sequence) + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, s.path(sequence)))
+}
+
+func (s *FileStore[T]) Prune(beforeSequence int64) error {
+	return s.remove(func(sequence int64) bool {
+		return sequence < beforeSequence
+	})
+}
+
+func (s *FileStore[T]) Invalidate(fromSequence int64) error {
+	return s.remove(func(sequence int64) bool {
+		return sequence >= fromSequence
+	})
+}
+
+func (s *FileStore[T]) remove(match func(int64) bool) error {
+	sequences, err := s.list()
+	if err != nil {
+		return err
+	}
+	for _, sequence := range sequences {
+		if !match(sequence) {
+			continue
+		}
+		err = os.Remove(s.path(sequence))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// list 返回 dir 下所有结果的 sequence
+func (s *FileStore[T]) list() ([]int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sequences := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		sequence, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		sequences = append(sequences, sequence)
+	}
+	return sequences, nil
+}
+
+// SetStore nil 表示关闭，需要在开始工作前设置
+func (t *Tentacle[T]) SetStore(store Store[T]) *Tentacle[T] {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.store = store
+	t.saver.storeMutex.Lock()
+	t.saver.store = store
+	t.saver.storeMutex.Unlock()
+	return t
+}
+
+/*
+saver 负责所有对 Store 的调用
+Save 在独立的协程中批量进行，不持有 Tentacle 的锁，其余的调用由 Tentacle 在持有锁时同步进行
+
+storeMutex 保证 Store 的方法不会被并发调用，持有 storeMutex 时不会再获取 Tentacle 的锁及 mutex
+mutex 保护 pending, epoch 及 pruned
+*/
+type saver[T any] struct {
+	storeMutex sync.Mutex
+	store      Store[T]
+
+	mutex   sync.Mutex
+	pending map[int64]T   // 尚未保存的结果
+	epoch   uint64        // Rewind 时增加，之前取出、尚未保存的结果作废
+	pruned  int64         // 最后一次 Prune 的位置，小于该位置的结果不再保存
+	notify  chan struct{} // 有新的结果需要保存
+}
+
+func newSaver[T any]() *saver[T] {
+	return &saver[T]{
+		pending: make(map[int64]T),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// add 记录需要保存的结果，不会阻塞
+func (s *saver[T]) add(sequence int64, value T) {
+	s.mutex.Lock()
+	s.pending[sequence] = value
+	s.mutex.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// flush 保存 pending 中的所有结果
+func (s *saver[T]) flush() {
+	s.mutex.Lock()
+	values, epoch := s.pending, s.epoch
+	s.pending = make(map[int64]T)
+	s.mutex.Unlock()
+	if len(values) == 0 {
+		return
+	}
+
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+	s.mutex.Lock()
+	stale, pruned := epoch != s.epoch, s.pruned
+	s.mutex.Unlock()
+	if stale || s.store == nil {
+		// 取出后发生了 Rewind，可能包含已作废的结果
+		return
+	}
+	for sequence := range values {
+		if sequence < pruned {
+			delete(values, sequence)
+		}
+	}
+	err := s.store.Save(values)
+	if err != nil {
+		log.Entry.WithError(err).Error(err)
+	}
+}
+
+// run 持续保存结果，ctx 结束时保存剩余的结果后返回
+func (s *saver[T]) run(ctx context.Context) {
+	for {
+		select {
+		case <-s.notify:
+			s.flush()
+		case <-ctx.Done():
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *saver[T]) loadFrom(fromSequence int64) (map[int64]T, error) {
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+	return s.store.LoadFrom(fromSequence)
+}
+
+func (s *saver[T]) prune(beforeSequence int64) error {
+	s.mutex.Lock()
+	s.pruned = beforeSequence
+	for sequence := range s.pending {
+		if sequence < beforeSequence {
+			delete(s.pending, sequence)
+		}
+	}
+	s.mutex.Unlock()
+
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+	return s.store.Prune(beforeSequence)
+}
+
+// invalidate 同时作废尚未保存的结果
+func (s *saver[T]) invalidate(fromSequence int64) error {
+	s.mutex.Lock()
+	s.epoch++
+	for sequence := range s.pending {
+		if sequence >= fromSequence {
+			delete(s.pending, sequence)
+		}
+	}
+	s.mutex.Unlock()
+
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+	return s.store.Invalidate(fromSequence)
+}
+
+// runSaverLocked 需要持有写锁
+func (t *Tentacle[T]) runSaverLocked() {
+	if t.store == nil {
+		return
+	}
+	running := t.running
+	running.Add(1)
+	go func(ctx context.Context) {
+		defer running.Done()
+		t.saver.run(ctx)
+	}(t.ctx)
+}
+
+// restore 需要持有写锁，开始工作时读取 store 中的结果，出错时按没有结果处理
+func (t *Tentacle[T]) restore(fromSequence int64) {
+	t.restored = nil
+	if t.store == nil {
+		return
+	}
+	values, err := t.saver.loadFrom(fromSequence)
+	if err != nil {
+		log.Entry.WithError(err).Error(err)
+		return
+	}
+	t.restored = values
+}
+
+// load 需要持有写锁，命中 restore 读取的结果时，不再分发给 worker
+func (t *Tentacle[T]) load(sequence int64) (*box[T], bool) {
+	value, ok := t.restored[sequence]
+	if !ok {
+		return nil, false
+	}
+	delete(t.restored, sequence)
+	return &box[T]{sequence: sequence, result: value}, true
+}
+
+// save 需要持有写锁，交给 saver 异步保存
+func (t *Tentacle[T]) save(item *box[T]) {
+	if t.store == nil {
+		return
+	}
+	t.saver.add(item.sequence, item.result)
+}
+
+// prune 需要持有写锁，每移出 workLength 个结果才调用一次 Store.Prune
+func (t *Tentacle[T]) prune(beforeSequence int64) {
+	if t.store == nil || beforeSequence-t.pruned < t.workLength {
+		return
+	}
+	t.pruned = beforeSequence
+	err := t.saver.prune(beforeSequence)
+	if err != nil {
+		log.Entry.WithError(err).Error(err)
+	}
+}
@@ -0,0 +1,83 @@
+package tentacle
+
+import (
+	"context"
+	"iter"
+
+	"github.com/pkg/errors"
+)
+
+// StreamItem Stream 返回的单个结果
+type StreamItem[T Cloner[T]] struct {
+	Sequence int64
+	Value    T
+}
+
+/*
+Stream 从 from 开始按顺序返回结果，到达 maxSequence 后等待 UpdateMaxSequence，直至 ctx 结束
+消费方处理完当前结果才会获取下一个，预取数量依然受 workLength 限制
+
+错误通过迭代器返回:
+  - 某个 sequence 最终失败(FailureFail)时，返回该 sequence 的错误，之后继续下一个 sequence
+  - ctx 结束、Tentacle 被 Stop 或其他错误时，返回一次错误后结束
+
+Stream 期间，不能再调用 Get 或 Rewind
+*/
+func (t *Tentacle[T]) Stream(ctx context.Context, from int64) iter.Seq2[StreamItem[T], error] {
+	return func(yield func(StreamItem[T], error) bool) {
+		t.mutex.RLock()
+		epoch := t.epoch
+		t.mutex.RUnlock()
+
+		for sequence := from; ; sequence++ {
+			item := StreamItem[T]{Sequence: sequence}
+			err := t.waitMaxSequence(ctx, epoch, sequence)
+			if err != nil {
+				yield(item, err)
+				return
+			}
+			item.Value, err = t.GetContext(ctx, sequence)
+			if err != nil && !t.failed(sequence) {
+				yield(item, err)
+				return
+			}
+			if !yield(item, err) {
+				return
+			}
+		}
+	}
+}
+
+// waitMaxSequence 等待 maxSequence >= sequence，期间 Tentacle 被 Stop 时返回 ErrStopped
+func (t *Tentacle[T]) waitMaxSequence(ctx context.Context, epoch uint64, sequence int64) error {
+	for {
+		t.mutex.RLock()
+		current, maxSequence, updated := t.epoch, t.cursor.maxSequence, t.updated
+		t.mutex.RUnlock()
+		if current != epoch {
+			return ErrStopped
+		}
+		if sequence <= maxSequence {
+			return nil
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
+}
+
+// failed sequence 是否最终失败(FailureFail)
+func (t *Tentacle[T]) failed(sequence int64) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	_, ok := t.failedArea[sequence]
+	return ok
+}
+
+// notifyUpdated 需要持有写锁，唤醒所有等待 maxSequence 的 Stream
+func (t *Tentacle[T]) notifyUpdated() {
+	close(t.updated)
+	t.updated = make(chan struct{})
+}
@@ -3,13 +3,18 @@ package tentacle
 // my English is pool, so i doc it with Chinese
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/LukeEuler/dolly/log"
 )
 
+// ErrStopped Tentacle 被 Stop, 或者 Start 传入的 ctx 已结束
+var ErrStopped = errors.New("tentacle stopped")
+
 // Tentacle implement ITentacle
 type Tentacle[T Cloner[T]] struct {
 	mutex sync.RWMutex
@@ -27,6 +32,27 @@ type Tentacle[T Cloner[T]] struct {
 	*/
 	cursor cursor
 
+	/*
+		scale 为期望的 worker 数，默认为 concurrent，可通过 SetConcurrency 或自动伸缩调整，范围 [1, workLength]
+		workers 为当前运行的 worker 的退出方法，新增的 worker 放在末尾，退出时从末尾开始
+		latency 为 worker 处理单个 sequence 耗时的 EWMA
+	*/
+	scale     int64
+	workers   []context.CancelFunc
+	autoScale *AutoScale
+	latency   time.Duration
+
+	/*
+		每次开始工作时(startWork)创建一个新的运行周期
+		parent 由 Start 设置，默认为 context.Background()
+		ctx 结束后，所有 worker 及 writeResults 协程都会退出，退出完成后关闭 done
+	*/
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running *sync.WaitGroup // worker 以及重试等待协程
+
 	/*
 		inputs 负责给 worker 分发任务
 		outputs 负责接收 worker 的结果
@@ -38,7 +64,47 @@ type Tentacle[T Cloner[T]] struct {
 
 	// outputs -> cache -> queue， 释放 outputs 空间，且在 queue 无法接收的情况下做一个缓存区
 	cacheArea    map[int64]*box[T]
-	reservedArea map[int64]T // 数据保留区，可重复查询
+	reservedArea map[int64]T     // 数据保留区，可重复查询
+	failedArea   map[int64]error // 保留区中最终失败(FailureFail)的 sequence
+
+	/*
+		重试策略，maxAttempts <= 0 时无限重试
+		attempts 记录每个 sequence 已失败的次数，paused 记录被 FailurePause 挂起的 sequence
+		waiting 记录正在等待 backoff 的 sequence 及本次重试的编号(retries)，Rewind 时作废
+	*/
+	maxAttempts int
+	backoff     Backoff
+	onFailure   FailureHandler
+	attempts    map[int64]int
+	paused      map[int64]error
+	waiting     map[int64]uint64
+	retries     uint64
+
+	/*
+		Rewind 时仍在 worker 中处理的 sequence，其结果到达时丢弃
+		值为 true 表示期间已被分发(pushInput)，需要在旧的结果到达后重新放入 inputs
+	*/
+	stale map[int64]bool
+
+	// 已分发、尚未按顺序完成的 sequence 及其首次分发的时间，用于 Stats
+	dispatched map[int64]time.Time
+	metrics    *Metrics
+
+	/*
+		已完成结果的持久化存储，可选
+		restored 为开始工作时从 store 中读取、尚未分发的结果，pruned 为最后一次 Prune 的位置
+	*/
+	store    Store[T]
+	saver    *saver[T]
+	restored map[int64]T
+	pruned   int64
+
+	/*
+		updated 在 maxSequence 增大或 Stop 时关闭并替换，用于唤醒 Stream
+		epoch 在每次 Stop 时增加，Stream 据此判断 Tentacle 是否被 Stop 过
+	*/
+	updated chan struct{}
+	epoch   uint64
 }
 
 type cursor struct {
@@ -77,15 +143,25 @@ func NewTentacle[T Cloner[T]](concurrent, redundancy, reservedLength int64, wf F
 	*/
 	return &Tentacle[T]{
 		concurrent:     concurrent,
+		scale:          concurrent,
 		generate:       wf,
 		workLength:     workLength,
 		reservedLength: reservedLength,
-		inputs:         make(chan int64, workLength),
-		outputs:        make(chan *box[T], workLength),
-		queue:          make(chan *box[T], workLength),
+		parent:         context.Background(),
+		backoff:        ConstantBackoff(time.Second),
 
 		cacheArea:    make(map[int64]*box[T], concurrent),
 		reservedArea: make(map[int64]T, reservedLength+1),
+		failedArea:   make(map[int64]error),
+
+		attempts: make(map[int64]int),
+		paused:   make(map[int64]error),
+		waiting:  make(map[int64]uint64),
+		stale:    make(map[int64]bool),
+
+		dispatched: make(map[int64]time.Time, workLength),
+		saver:      newSaver[T](),
+		updated:    make(chan struct{}),
 
 		cursor: cursor{
 			workStarted:       false,
@@ -94,20 +170,249 @@ func NewTentacle[T Cloner[T]](concurrent, redundancy, reservedLength int64, wf F
 	}
 }
 
+/*
+SetRetry 设置每个 sequence 的最大尝试次数，以及每次失败后重新分发前的等待时间
+maxAttempts <= 0 时无限重试(默认)；backoff 默认为 ConstantBackoff(time.Second)，为 nil 时立即重试
+超出次数后，由 SetFailureHandler 设置的方法决定如何处理，默认为 FailureFail
+*/
+func (t *Tentacle[T]) SetRetry(maxAttempts int, backoff Backoff) *Tentacle[T] {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.maxAttempts = maxAttempts
+	t.backoff = backoff
+	return t
+}
+
+func (t *Tentacle[T]) SetFailureHandler(handler FailureHandler) *Tentacle[T] {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onFailure = handler
+	return t
+}
+
+// Resume 重新分发所有被 FailurePause 挂起的 sequence，其失败次数清零
+func (t *Tentacle[T]) Resume() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.cursor.workStarted {
+		return
+	}
+	for sequence := range t.paused {
+		delete(t.paused, sequence)
+		delete(t.attempts, sequence)
+		if !t.pushInput(sequence) {
+			return
+		}
+	}
+}
+
+/*
+Start 以 ctx 为生命周期，从 sequence 开始预取
+ctx 结束后，所有 worker 都会退出，Get 返回 ErrStopped，此时需要 Stop 后才能重新使用
+
+不调用 Start 时，第一次 Get 会以 context.Background() 自动开始
+*/
+func (t *Tentacle[T]) Start(ctx context.Context, sequence int64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.cursor.workStarted {
+		return errors.New("tentacle already started")
+	}
+	t.parent = ctx
+	return t.startWorkLocked(sequence)
+}
+
+// Stop 通知所有 worker 退出并等待其结束，然后将 Tentacle 恢复到初始状态
 func (t *Tentacle[T]) Stop() {
-	t.mutex.RLock()
-	defer t.mutex.RUnlock()
-	t.inputs = make(chan int64, t.workLength)
-	t.outputs = make(chan *box[T], t.workLength)
-	t.queue = make(chan *box[T], t.workLength)
+	t.wait(t.shutdown(), nil)
+	t.reset()
+}
+
+/*
+StopWithTimeout 同 Stop，但最多等待 timeout
+超时返回 error，此时 Tentacle 依然会恢复到初始状态，未退出的协程会在手头的任务结束后自行退出
+*/
+func (t *Tentacle[T]) StopWithTimeout(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ok := t.wait(t.shutdown(), timer.C)
+	t.reset()
+	if !ok {
+		return errors.Errorf("tentacle stop timeout(%s)", timeout)
+	}
+	return nil
+}
+
+// shutdown 结束当前运行周期，返回其 done
+func (t *Tentacle[T]) shutdown() chan struct{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	return t.done
+}
+
+func (t *Tentacle[T]) wait(done chan struct{}, timeout <-chan time.Time) bool {
+	if done == nil {
+		return true
+	}
+	select {
+	case <-done:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+func (t *Tentacle[T]) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.parent = context.Background()
+	t.ctx, t.cancel, t.done, t.running = nil, nil, nil, nil
+	t.workers = nil
+	t.latency = 0
+	t.inputs, t.outputs, t.queue = nil, nil, nil
 	t.cacheArea = make(map[int64]*box[T], t.concurrent)
 	t.reservedArea = make(map[int64]T, t.reservedLength+1)
+	t.failedArea = make(map[int64]error)
+	t.attempts = make(map[int64]int)
+	t.paused = make(map[int64]error)
+	t.waiting = make(map[int64]uint64)
+	t.stale = make(map[int64]bool)
+	t.restored, t.pruned = nil, 0
+	t.dispatched = make(map[int64]time.Time, t.workLength)
+	t.epoch++
+	t.notifyUpdated()
 	t.cursor = cursor{
 		workStarted:       false,
 		reservedAreaEmpty: true,
 	}
 }
 
+/*
+Rewind 回退到 toSequence，用于处理区块链的 reorg
+所有大于 toSequence 的结果(保留区、缓存区、queue 以及正在处理中的)都会作废，并从 toSequence+1 开始重新预取
+小于等于 toSequence 的结果不受影响
+
+之后的 Get 需要满足 sequence <= toSequence+1，maxSequence 不会改变
+*/
+func (t *Tentacle[T]) Rewind(toSequence int64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.cursor.workStarted {
+		return nil
+	}
+	if t.ctx.Err() != nil {
+		return ErrStopped
+	}
+	if t.store != nil {
+		// store 中可能有上次运行时保存的结果，即便这次还没有分发过
+		err := t.saver.invalidate(toSequence + 1)
+		if err != nil {
+			return err
+		}
+	}
+	for sequence := range t.restored {
+		if sequence > toSequence {
+			delete(t.restored, sequence)
+		}
+	}
+	if toSequence >= t.cursor.lastInputsSequence {
+		// 没有分发过大于 toSequence 的任务
+		return nil
+	}
+
+	// 记录仍在 inputs，queue，cacheArea，reservedArea 或被挂起的 sequence，其余的即为处理中的
+	settled := make(map[int64]bool, t.workLength)
+	for _, value := range drain(t.inputs) {
+		settled[value] = true
+		if value <= toSequence {
+			t.inputs <- value
+		}
+	}
+	for _, item := range drain(t.queue) {
+		settled[item.sequence] = true
+		if item.sequence <= toSequence {
+			t.queue <- item
+		}
+	}
+	for sequence := range t.cacheArea {
+		settled[sequence] = true
+		if sequence > toSequence {
+			delete(t.cacheArea, sequence)
+		}
+	}
+	for sequence := range t.paused {
+		settled[sequence] = true
+		if sequence > toSequence {
+			delete(t.paused, sequence)
+		}
+	}
+	for sequence := range t.waiting {
+		settled[sequence] = true
+		if sequence > toSequence {
+			delete(t.waiting, sequence)
+		}
+	}
+	for sequence := range t.attempts {
+		if sequence > toSequence {
+			delete(t.attempts, sequence)
+		}
+	}
+	for sequence := range t.dispatched {
+		if sequence > toSequence {
+			delete(t.dispatched, sequence)
+		}
+	}
+	for sequence := range t.reservedArea {
+		settled[sequence] = true
+		if sequence > toSequence {
+			delete(t.reservedArea, sequence)
+			delete(t.failedArea, sequence)
+		}
+	}
+	// 小于等于 lastQueueSequence 的已经进入 queue 或被消费掉了
+	for sequence := max(toSequence, t.cursor.lastQueueSequence) + 1; sequence <= t.cursor.lastInputsSequence; sequence++ {
+		if !settled[sequence] {
+			t.stale[sequence] = false
+		}
+	}
+
+	if t.cursor.reservedAreaMax > toSequence {
+		t.cursor.reservedAreaMax = toSequence
+		if t.cursor.reservedAreaEmpty || t.cursor.reservedAreaMax < t.cursor.reservedAreaMin {
+			t.cursor.reservedAreaEmpty = true
+			t.cursor.reservedAreaMin = toSequence
+		}
+	}
+	t.cursor.lastQueueSequence = min(t.cursor.lastQueueSequence, toSequence)
+	t.cursor.lastInputsSequence = toSequence
+
+	maxValue := min(t.cursor.maxSequence, t.cursor.reservedAreaMax+t.workLength)
+	for value := toSequence + 1; value <= maxValue; value++ {
+		t.cursor.lastInputsSequence = value
+		if !t.pushInput(value) {
+			return ErrStopped
+		}
+	}
+	return nil
+}
+
+// drain 取出 channel 中当前所有的元素
+func drain[E any](ch chan E) []E {
+	list := make([]E, 0, len(ch))
+	for {
+		select {
+		case item := <-ch:
+			list = append(list, item)
+		default:
+			return list
+		}
+	}
+}
+
 // UpdateMaxSequence implement ITentacle
 func (t *Tentacle[T]) UpdateMaxSequence(sequence int64) error {
 	t.mutex.Lock()
@@ -116,6 +421,9 @@ func (t *Tentacle[T]) UpdateMaxSequence(sequence int64) error {
 		return errors.Errorf("can not set max sequence as %d, while last max sequence is %d",
 			sequence, t.cursor.maxSequence)
 	}
+	if sequence > t.cursor.maxSequence {
+		defer t.notifyUpdated()
+	}
 	t.cursor.maxSequence = sequence
 	if !t.cursor.workStarted {
 		return nil
@@ -123,12 +431,38 @@ func (t *Tentacle[T]) UpdateMaxSequence(sequence int64) error {
 	maxValue := min(t.cursor.maxSequence, t.cursor.reservedAreaMax+t.workLength)
 	// 运行过程中，如果更新了 max sequence, 则可能需要加入新的任务
 	for value := t.cursor.lastInputsSequence + 1; value <= maxValue; value++ {
-		t.inputs <- value
+		if !t.pushInput(value) {
+			return ErrStopped
+		}
 		t.cursor.lastInputsSequence = value
 	}
 	return nil
 }
 
+/*
+pushInput 需要持有写锁，store 中已有结果时不再分发给 worker
+Rewind 前分发的 sequence 仍在处理中时，等待其结果到达后再分发(见 writeResult)
+*/
+func (t *Tentacle[T]) pushInput(value int64) bool {
+	if _, ok := t.stale[value]; ok {
+		t.dispatch(value)
+		t.stale[value] = true
+		return true
+	}
+	if item, ok := t.load(value); ok {
+		t.dispatch(value)
+		t.queueResult(item)
+		return t.ctx.Err() == nil
+	}
+	select {
+	case t.inputs <- value:
+		t.dispatch(value)
+		return true
+	case <-t.ctx.Done():
+		return false
+	}
+}
+
 func (t *Tentacle[T]) startWork(sequence int64) error {
 	t.mutex.RLock()
 	startStatus := t.cursor.workStarted
@@ -138,32 +472,71 @@ func (t *Tentacle[T]) startWork(sequence int64) error {
 	}
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if t.cursor.workStarted {
+		return nil
+	}
+	return t.startWorkLocked(sequence)
+}
+
+func (t *Tentacle[T]) startWorkLocked(sequence int64) error {
+	// 先创建全部 worker，避免创建失败时还要清理已经运行的协程
+	workers := make([]Worker[T], 0, t.scale)
+	for i := int64(0); i < t.scale; i++ {
+		worker, err := t.generate()
+		if err != nil {
+			return err
+		}
+		workers = append(workers, worker)
+	}
+
+	t.ctx, t.cancel = context.WithCancel(t.parent)
+	t.done = make(chan struct{})
+	t.inputs = make(chan int64, t.workLength)
+	t.outputs = make(chan *box[T], t.workLength)
+	t.queue = make(chan *box[T], t.workLength)
+
 	t.cursor.reservedAreaEmpty = true
 	t.cursor.reservedAreaMin = sequence - 1
 	t.cursor.reservedAreaMax = sequence - 1
 	t.cursor.lastQueueSequence = sequence - 1
 
+	t.restore(sequence)
 	for i := int64(0); i < t.workLength; i++ {
 		value := i + sequence
 		if value <= t.cursor.maxSequence {
-			t.inputs <- value
+			t.pushInput(value)
 			t.cursor.lastInputsSequence = value
 		}
 	}
 
-	for i := int64(0); i < t.concurrent; i++ {
-		worker, err := t.generate()
-		if err != nil {
-			return err
-		}
-		go worker(t.inputs, t.outputs)
+	t.running = new(sync.WaitGroup)
+	t.workers = make([]context.CancelFunc, 0, t.workLength)
+	for _, worker := range workers {
+		t.runWorkerLocked(worker)
 	}
 
-	t.writeResults()
+	t.runSaverLocked()
+	t.writeResults(t.ctx, t.outputs, t.running, t.done)
+	if t.autoScale != nil {
+		t.runAutoScaleLocked(t.autoScale)
+	}
 	t.cursor.workStarted = true
 	return nil
 }
 
+// runWorkerLocked 需要持有写锁，每个 worker 有独立的 ctx，用于单独让其退出
+func (t *Tentacle[T]) runWorkerLocked(worker Worker[T]) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	t.workers = append(t.workers, cancel)
+	running := t.running
+	running.Add(1)
+	go func(inputs chan int64, outputs chan *box[T]) {
+		defer running.Done()
+		defer cancel()
+		worker(ctx, inputs, outputs)
+	}(t.inputs, t.outputs)
+}
+
 func (t *Tentacle[T]) copyCursor() cursor {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
@@ -171,6 +544,14 @@ func (t *Tentacle[T]) copyCursor() cursor {
 }
 
 func (t *Tentacle[T]) Get(sequence int64) (T, error) {
+	return t.GetContext(context.Background(), sequence)
+}
+
+/*
+GetContext 同 Get，在 ctx 结束时返回 ctx 的错误
+等待期间不会消费任何结果，之后可以再次 Get 同一个 sequence
+*/
+func (t *Tentacle[T]) GetContext(ctx context.Context, sequence int64) (T, error) {
 	// 涉及读锁
 	cursorState := t.copyCursor()
 
@@ -193,32 +574,66 @@ func (t *Tentacle[T]) Get(sequence int64) (T, error) {
 	}
 
 	// 涉及写锁
-	return t.readFromReserved(sequence)
+	return t.readFromReserved(ctx, sequence)
 }
 
-func (t *Tentacle[T]) readFromReserved(sequence int64) (T, error) {
+func (t *Tentacle[T]) readFromReserved(ctx context.Context, sequence int64) (T, error) {
 	for {
-		// 涉及读锁
-		cursorState := t.copyCursor()
-		// cursorState.reservedAreaMin <= sequence <= cursorState.reservedAreaMax
-		if cursorState.reservedAreaMin <= sequence && sequence <= cursorState.reservedAreaMax {
-			res, ok := t.reservedArea[sequence]
-			if !ok {
-				return res, errors.Errorf("something wrong: miss %d(reserved min %d, max %d)",
-					sequence, cursorState.reservedAreaMin, cursorState.reservedAreaMax)
-			}
-			return res.Clone(), nil
+		res, ok, err := t.lookupReserved(sequence)
+		if ok || err != nil {
+			return res, err
 		}
 		// 涉及写锁
-		t.readOneFromQueue()
+		err = t.readOneFromQueue(ctx)
+		if err != nil {
+			return res, err
+		}
 	}
 }
 
-func (t *Tentacle[T]) readOneFromQueue() {
-	newBox := <-t.queue
+func (t *Tentacle[T]) lookupReserved(sequence int64) (T, bool, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	// t.cursor.reservedAreaMin <= sequence <= t.cursor.reservedAreaMax
+	if t.cursor.reservedAreaMin <= sequence && sequence <= t.cursor.reservedAreaMax {
+		res, ok := t.reservedArea[sequence]
+		if !ok {
+			return res, false, errors.Errorf("something wrong: miss %d(reserved min %d, max %d)",
+				sequence, t.cursor.reservedAreaMin, t.cursor.reservedAreaMax)
+		}
+		if err, failed := t.failedArea[sequence]; failed {
+			return res, true, err
+		}
+		return res.Clone(), true, nil
+	}
+	var emp T
+	return emp, false, nil
+}
+
+func (t *Tentacle[T]) readOneFromQueue(ctx context.Context) error {
+	t.mutex.RLock()
+	runCtx, queue := t.ctx, t.queue
+	t.mutex.RUnlock()
+	if runCtx == nil {
+		return ErrStopped
+	}
+
+	var newBox *box[T]
+	select {
+	case newBox = <-queue:
+	case <-runCtx.Done():
+		return ErrStopped
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+
 	nextSequence := newBox.sequence + t.workLength
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if t.ctx != runCtx {
+		// 等待期间被 Stop 了
+		return ErrStopped
+	}
 	if nextSequence <= t.cursor.maxSequence && nextSequence > t.cursor.lastInputsSequence {
 		/*
 			注意，注释中的写法是不对的，且难以发现这个bug
@@ -227,48 +642,93 @@ func (t *Tentacle[T]) readOneFromQueue() {
 		*/
 		maxValue := nextSequence
 		for value := t.cursor.lastInputsSequence + 1; value <= maxValue; value++ {
-			t.inputs <- value
+			if !t.pushInput(value) {
+				return ErrStopped
+			}
 			t.cursor.lastInputsSequence = value
 		}
 	}
 
 	t.cursor.reservedAreaMax = newBox.sequence
 	t.reservedArea[newBox.sequence] = newBox.result
+	if newBox.err != nil {
+		// 最终失败的 sequence，Get 时返回其错误
+		t.failedArea[newBox.sequence] = newBox.err
+	}
 
 	if t.cursor.reservedAreaEmpty {
-		// assert newBoc.err == nil
 		// t.reservedLength >= 1
 		t.cursor.reservedAreaEmpty = false
 		t.cursor.reservedAreaMin = newBox.sequence
-		return
+		return nil
 	}
 	// !t.cursor.reservedAreaEmpty
 	// asset newBoc.sequence = t.cursor.reservedAreaMax + 1
 	if (newBox.sequence - t.cursor.reservedAreaMin) == t.reservedLength {
 		delete(t.reservedArea, t.cursor.reservedAreaMin)
+		delete(t.failedArea, t.cursor.reservedAreaMin)
 		t.cursor.reservedAreaMin++
+		t.prune(t.cursor.reservedAreaMin)
 	}
+	return nil
 }
 
-func (t *Tentacle[T]) writeResults() {
+/*
+writeResults 持续处理 outputs 中的结果
+所有 worker 退出后关闭 outputs，处理完剩余结果后关闭 done
+ctx 结束后 worker 仍可能在发送手头的结果，所以 outputs 需要一直被读取，直至关闭
+*/
+func (t *Tentacle[T]) writeResults(ctx context.Context, outputs chan *box[T], running *sync.WaitGroup,
+	done chan struct{}) {
 	go func() {
-		for item := range t.outputs {
-			t.writeResult(item)
+		running.Wait()
+		close(outputs)
+	}()
+	go func() {
+		defer close(done)
+		for item := range outputs {
+			t.writeResult(ctx, item)
 		}
 	}()
 }
 
-func (t *Tentacle[T]) writeResult(item *box[T]) {
+func (t *Tentacle[T]) writeResult(ctx context.Context, item *box[T]) {
 	// 此时能够拿到数据，一定是 cursor.workStarted == true
 	// 且 cursor.lastQueueSequence = sequence - 1，初始化完成了
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if ctx.Err() != nil {
+		// 已经 Stop，丢弃结果
+		return
+	}
+	if pushed, ok := t.stale[item.sequence]; ok {
+		// Rewind 之前分发的结果，丢弃；期间已被分发的，此时才放入 inputs
+		delete(t.stale, item.sequence)
+		if pushed {
+			t.pushInput(item.sequence)
+		}
+		return
+	}
+	if item.cost > 0 {
+		t.observeLatency(item.cost)
+	}
+	if t.metrics != nil && t.metrics.OnResult != nil {
+		t.metrics.OnResult(item.sequence, item.cost, t.attempts[item.sequence]+1, item.err)
+	}
 	if item.err != nil {
 		log.Entry.WithError(item.err).Error(item.err)
-		// item.sequence <= t.cursor.maxSequence
-		t.inputs <- item.sequence
-		return
+		if !t.handleFailure(ctx, item) {
+			return
+		}
+	} else {
+		t.save(item)
 	}
+	delete(t.attempts, item.sequence)
+	t.queueResult(item)
+}
+
+// queueResult 需要持有写锁，按顺序将结果放入 queue，暂时无法放入的先放入 cacheArea
+func (t *Tentacle[T]) queueResult(item *box[T]) {
 
 	// item.sequence > cursor.lastQueueSequence
 	if item.sequence > t.cursor.lastQueueSequence+1 {
@@ -278,7 +738,10 @@ func (t *Tentacle[T]) writeResult(item *box[T]) {
 	}
 	// item.sequence == cursor.lastQueueSequence + 1
 	index := item.sequence + 1
-	t.queue <- item
+	if !t.pushQueue(item) {
+		return
+	}
+	t.complete(item)
 	t.cursor.lastQueueSequence = item.sequence
 	for {
 		// 尝试清理缓存
@@ -286,9 +749,88 @@ func (t *Tentacle[T]) writeResult(item *box[T]) {
 		if !ok {
 			break
 		}
-		t.queue <- item
+		if !t.pushQueue(item) {
+			return
+		}
 		delete(t.cacheArea, index)
+		t.complete(item)
 		index++
 		t.cursor.lastQueueSequence = item.sequence
 	}
 }
+
+/*
+handleFailure 需要持有写锁
+返回 true 表示 item 需要按正常结果放入 queue(FailureSkip 或 FailureFail)
+*/
+func (t *Tentacle[T]) handleFailure(ctx context.Context, item *box[T]) bool {
+	t.attempts[item.sequence]++
+	attempts := t.attempts[item.sequence]
+	if t.maxAttempts <= 0 || attempts < t.maxAttempts {
+		t.retry(ctx, item.sequence, attempts)
+		return false
+	}
+
+	action := FailureFail
+	if t.onFailure != nil {
+		action = t.onFailure(item.sequence, attempts, item.err)
+	}
+	switch action {
+	case FailureRetry:
+		delete(t.attempts, item.sequence)
+		t.retry(ctx, item.sequence, attempts)
+		return false
+	case FailurePause:
+		log.Entry.WithField("tentacle", "pause").Warnf("sequence %d paused after %d attempts", item.sequence, attempts)
+		t.paused[item.sequence] = item.err
+		return false
+	case FailureSkip:
+		log.Entry.WithField("tentacle", "skip").Warnf("sequence %d skipped after %d attempts", item.sequence, attempts)
+		var emp T
+		item.result, item.err = emp, nil
+		return true
+	default:
+		item.err = errors.Wrapf(item.err, "sequence %d failed after %d attempts", item.sequence, attempts)
+		return true
+	}
+}
+
+// retry 需要持有写锁，backoff 不为 0 时在独立的协程中等待后重新分发
+func (t *Tentacle[T]) retry(ctx context.Context, sequence int64, attempts int) {
+	var wait time.Duration
+	if t.backoff != nil {
+		wait = t.backoff(attempts)
+	}
+	if wait <= 0 {
+		// item.sequence <= t.cursor.maxSequence
+		t.pushInput(sequence)
+		return
+	}
+	t.retries++
+	id := t.retries
+	t.waiting[sequence] = id
+	running := t.running
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		sleep(ctx, wait)
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if ctx.Err() != nil || t.waiting[sequence] != id {
+			// 已经 Stop，或者等待期间被 Rewind 作废
+			return
+		}
+		delete(t.waiting, sequence)
+		t.pushInput(sequence)
+	}()
+}
+
+// pushQueue 需要持有写锁
+func (t *Tentacle[T]) pushQueue(item *box[T]) bool {
+	select {
+	case t.queue <- item:
+		return true
+	case <-t.ctx.Done():
+		return false
+	}
+}
@@ -1,11 +1,17 @@
 package tentacle
 
 import (
+	"context"
 	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/LukeEuler/dolly/common/tree"
 )
 
 type mint64 int64
@@ -20,9 +26,14 @@ func testNewFactory(f func(int64, int64) (mint64, error)) Factory[mint64] {
 	tenFailed := false
 	return func() (Worker[mint64], error) {
 		// xxxx
-		return func(inputs chan int64, outputs chan *box[mint64]) {
+		return func(ctx context.Context, inputs chan int64, outputs chan *box[mint64]) {
 			for {
-				sequence := <-inputs
+				var sequence int64
+				select {
+				case <-ctx.Done():
+					return
+				case sequence = <-inputs:
+				}
 				res, err := f(salt, sequence)
 				if sequence == 10 && !tenFailed {
 					tenFailed = true
@@ -151,3 +162,687 @@ func TestNewTentacleV2(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, mint64(114), value)
 }
+
+// waitGoroutines 等待协程数回落到 n 以下
+func waitGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("goroutine leak: %d > %d\n%s", runtime.NumGoroutine(), n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTentacleStop(t *testing.T) {
+	base := runtime.NumGoroutine()
+	tentacle := NewTentacle(4, 2, 2, testNewFactory(testHandleSequenceV1))
+	err := tentacle.UpdateMaxSequence(100)
+	assert.NoError(t, err)
+
+	for i := int64(1); i <= 20; i++ {
+		value, err := tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(110+i), value)
+	}
+	assert.Greater(t, runtime.NumGoroutine(), base)
+	tentacle.Stop()
+	waitGoroutines(t, base)
+
+	// Stop 之后可以重新使用
+	assert.NoError(t, tentacle.UpdateMaxSequence(50))
+	err = tentacle.Start(context.Background(), 30)
+	assert.NoError(t, err)
+	err = tentacle.Start(context.Background(), 30)
+	assert.Error(t, err)
+	value, err := tentacle.Get(30)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(140), value)
+	assert.NoError(t, tentacle.StopWithTimeout(time.Second))
+	waitGoroutines(t, base)
+}
+
+func TestTentacleContext(t *testing.T) {
+	base := runtime.NumGoroutine()
+	block := make(chan struct{})
+	tentacle := NewTentacle(2, 2, 2, testNewFactory(func(salt, sequence int64) (mint64, error) {
+		if sequence > 3 {
+			<-block
+		}
+		return mint64(salt + sequence), nil
+	}))
+	assert.NoError(t, tentacle.UpdateMaxSequence(100))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, tentacle.Start(ctx, 1))
+	value, err := tentacle.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(111), value)
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = tentacle.Get(2)
+	assert.NoError(t, err)
+	_, err = tentacle.Get(3)
+	assert.NoError(t, err)
+	_, err = tentacle.Get(4)
+	assert.ErrorIs(t, err, ErrStopped)
+
+	// worker 卡在任务上，无法在超时前退出
+	err = tentacle.StopWithTimeout(20 * time.Millisecond)
+	assert.Error(t, err)
+	close(block)
+	waitGoroutines(t, base)
+}
+
+func testPoisonFactory(poison int64, calls *atomic.Int64, healed *atomic.Bool) Factory[mint64] {
+	return testNewFactory(func(salt, sequence int64) (mint64, error) {
+		if sequence == poison {
+			calls.Add(1)
+			if !healed.Load() {
+				return 0, errors.New("poison")
+			}
+		}
+		return mint64(salt + sequence), nil
+	})
+}
+
+func TestTentacleRetry(t *testing.T) {
+	var calls atomic.Int64
+	var healed atomic.Bool
+	tentacle := NewTentacle(2, 2, 2, testPoisonFactory(5, &calls, &healed)).
+		SetRetry(3, ExponentialBackoff(time.Millisecond, 5*time.Millisecond))
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(20))
+
+	for i := int64(1); i <= 4; i++ {
+		_, err := tentacle.Get(i)
+		assert.NoError(t, err)
+	}
+	_, err := tentacle.Get(5)
+	assert.ErrorContains(t, err, "sequence 5 failed after 3 attempts")
+	assert.Equal(t, int64(3), calls.Load())
+	// 错误会一直保留，且不影响后续的 sequence
+	_, err = tentacle.Get(5)
+	assert.Error(t, err)
+	value, err := tentacle.Get(6)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(116), value)
+
+	// backoff 为 nil 时立即重试，worker 中不再等待
+	var failures atomic.Int64
+	tentacle = NewTentacle(2, 2, 2, NewWorkerFactory(func() (tree.Context, error) {
+		return nil, nil
+	}, func(_ tree.Context, sequence int64) (mint64, error) {
+		if sequence == 1 && failures.Add(1) < 3 {
+			return 0, errors.New("retry")
+		}
+		return mint64(sequence), nil
+	})).SetRetry(3, nil)
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(20))
+	start := time.Now()
+	value, err = tentacle.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(1), value)
+	assert.Equal(t, int64(3), failures.Load())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestTentacleFailureHandler(t *testing.T) {
+	var calls atomic.Int64
+	var healed atomic.Bool
+	tentacle := NewTentacle(2, 2, 2, testPoisonFactory(3, &calls, &healed)).
+		SetRetry(2, nil).
+		SetFailureHandler(func(sequence int64, attempts int, err error) FailureAction {
+			assert.Equal(t, int64(3), sequence)
+			assert.Equal(t, 2, attempts)
+			return FailureSkip
+		})
+	assert.NoError(t, tentacle.UpdateMaxSequence(20))
+	for i := int64(1); i <= 4; i++ {
+		value, err := tentacle.Get(i)
+		assert.NoError(t, err)
+		if i == 3 {
+			assert.Equal(t, mint64(0), value)
+		}
+	}
+	tentacle.Stop()
+
+	calls.Store(0)
+	tentacle.SetFailureHandler(func(int64, int, error) FailureAction {
+		return FailurePause
+	})
+	assert.NoError(t, tentacle.UpdateMaxSequence(20))
+	time.AfterFunc(50*time.Millisecond, func() {
+		healed.Store(true)
+		tentacle.Resume()
+	})
+	value, err := tentacle.Get(3)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(113), value)
+	assert.Equal(t, int64(3), calls.Load())
+	tentacle.Stop()
+}
+
+func TestTentacleGetContext(t *testing.T) {
+	stall := make(chan struct{})
+	tentacle := NewTentacle(2, 2, 2, testNewFactory(func(salt, sequence int64) (mint64, error) {
+		if sequence == 2 {
+			<-stall
+		}
+		return mint64(salt + sequence), nil
+	}))
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(10))
+
+	value, err := tentacle.GetContext(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(111), value)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = tentacle.GetContext(ctx, 2)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(stall)
+	value, err = tentacle.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(112), value)
+	value, err = tentacle.Get(3)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(113), value)
+}
+
+func TestTentacleRewind(t *testing.T) {
+	var fork atomic.Int64
+	tentacle := NewTentacle(3, 3, 4, testNewFactory(func(salt, sequence int64) (mint64, error) {
+		if sequence > 5 {
+			return mint64(salt + sequence + fork.Load()), nil
+		}
+		return mint64(salt + sequence), nil
+	}))
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(30))
+
+	for i := int64(1); i <= 8; i++ {
+		value, err := tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(110+i), value)
+	}
+
+	// 旧分叉上的 6,7,8 以及预取中的结果全部作废
+	fork.Store(1000)
+	assert.NoError(t, tentacle.Rewind(5))
+	value, err := tentacle.Get(5)
+	assert.NoError(t, err)
+	assert.Equal(t, mint64(115), value)
+	for i := int64(6); i <= 30; i++ {
+		value, err = tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(1110+i), value)
+	}
+
+	// 回退到保留区之前
+	fork.Store(2000)
+	assert.NoError(t, tentacle.Rewind(10))
+	for i := int64(11); i <= 30; i++ {
+		value, err = tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(2110+i), value)
+	}
+
+	// 处理中、且不在 Rewind 后的分发范围内的 sequence，只能在旧的结果返回后重新分发一次
+	var mutex sync.Mutex
+	runs := make(map[int64]int)
+	held := make(chan struct{})
+	release := make(chan struct{})
+	tentacle = NewTentacle(2, 2, 8, testNewFactory(func(salt, sequence int64) (mint64, error) {
+		mutex.Lock()
+		runs[sequence]++
+		first := runs[sequence] == 1
+		mutex.Unlock()
+		if sequence == 12 && first {
+			close(held)
+			<-release
+		}
+		return mint64(salt + sequence), nil
+	}))
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(30))
+
+	for i := int64(1); i <= 8; i++ {
+		_, err = tentacle.Get(i)
+		assert.NoError(t, err)
+	}
+	<-held
+
+	// 12 仍在 worker 中，不在 Rewind 后的分发范围内，之后由 Get 分发
+	assert.NoError(t, tentacle.Rewind(7))
+	for i := int64(8); i <= 11; i++ {
+		_, err = tentacle.Get(i)
+		assert.NoError(t, err)
+	}
+	close(release)
+	for i := int64(12); i <= 30; i++ {
+		value, err = tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(110+i), value)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, 2, runs[12])
+}
+
+func testCountingFactory(live *atomic.Int64, cost time.Duration) Factory[mint64] {
+	return func() (Worker[mint64], error) {
+		return func(ctx context.Context, inputs chan int64, outputs chan *box[mint64]) {
+			live.Add(1)
+			defer live.Add(-1)
+			for {
+				var sequence int64
+				select {
+				case <-ctx.Done():
+					return
+				case sequence = <-inputs:
+				}
+				time.Sleep(cost)
+				outputs <- &box[mint64]{sequence: sequence, result: mint64(sequence), cost: cost}
+			}
+		}, nil
+	}
+}
+
+func waitValue(t *testing.T, expected int64, f func() int64) {
+	deadline := time.Now().Add(2 * time.Second)
+	for f() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d, got %d", expected, f())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTentacleSetConcurrency(t *testing.T) {
+	var live atomic.Int64
+	tentacle := NewTentacle(2, 4, 2, testCountingFactory(&live, time.Millisecond))
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(1000))
+
+	get := func(from, to int64) {
+		for i := from; i <= to; i++ {
+			value, err := tentacle.Get(i)
+			assert.NoError(t, err)
+			assert.Equal(t, mint64(i), value)
+		}
+	}
+	get(1, 10)
+	assert.Equal(t, int64(2), live.Load())
+
+	assert.NoError(t, tentacle.SetConcurrency(6))
+	waitValue(t, 6, live.Load)
+	get(11, 100)
+
+	assert.NoError(t, tentacle.SetConcurrency(100))
+	assert.Equal(t, int64(8), tentacle.Concurrency())
+
+	assert.NoError(t, tentacle.SetConcurrency(1))
+	get(101, 200)
+	waitValue(t, 1, live.Load)
+	get(201, 300)
+}
+
+func TestTentacleSetConcurrencySlowFactory(t *testing.T) {
+	var live atomic.Int64
+	factory := testCountingFactory(&live, time.Millisecond)
+	var created atomic.Int64
+	release := make(chan struct{})
+	tentacle := NewTentacle(2, 4, 2, func() (Worker[mint64], error) {
+		// 开始工作后创建的 worker 阻塞到 release
+		if created.Add(1) > 2 {
+			<-release
+		}
+		return factory()
+	})
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(1000))
+	_, err := tentacle.Get(1)
+	assert.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tentacle.SetConcurrency(4)
+	}()
+	waitValue(t, 3, created.Load)
+
+	// Factory 阻塞期间不持有锁，Get 等操作不受影响
+	for i := int64(2); i <= 50; i++ {
+		value, err := tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(i), value)
+	}
+	assert.Equal(t, int64(4), tentacle.Concurrency())
+	assert.Equal(t, int64(2), live.Load())
+
+	close(release)
+	assert.NoError(t, <-done)
+	waitValue(t, 4, live.Load)
+}
+
+func TestTentacleAutoScale(t *testing.T) {
+	var live atomic.Int64
+	tentacle := NewTentacle(2, 4, 2, testCountingFactory(&live, 5*time.Millisecond)).
+		SetAutoScale(&AutoScale{Min: 1, Max: 8, Interval: 10 * time.Millisecond})
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(400))
+
+	// 追赶阶段，worker 逐渐增加
+	for i := int64(1); i <= 200; i++ {
+		_, err := tentacle.Get(i)
+		assert.NoError(t, err)
+	}
+	assert.Greater(t, tentacle.Concurrency(), int64(2))
+
+	// 消费方停下后，queue 积压，worker 逐渐减少
+	waitValue(t, 1, tentacle.Concurrency)
+	waitValue(t, 1, live.Load)
+}
+
+func TestTentacleStats(t *testing.T) {
+	var calls, results, failures, completed atomic.Int64
+	var healed atomic.Bool
+	tentacle := NewTentacle(2, 2, 2, testPoisonFactory(3, &calls, &healed)).
+		SetRetry(2, nil).
+		SetFailureHandler(func(int64, int, error) FailureAction {
+			return FailurePause
+		}).
+		SetMetrics(&Metrics{
+			OnResult: func(sequence int64, cost time.Duration, attempt int, err error) {
+				results.Add(1)
+				if err != nil {
+					failures.Add(1)
+				}
+			},
+			OnComplete: func(sequence int64, wait time.Duration, err error) {
+				completed.Add(1)
+			},
+		})
+	defer tentacle.Stop()
+
+	stats := tentacle.Stats()
+	assert.False(t, stats.Started)
+	assert.Equal(t, int64(0), stats.Lag())
+
+	assert.NoError(t, tentacle.UpdateMaxSequence(100))
+	_, err := tentacle.Get(1)
+	assert.NoError(t, err)
+
+	// 3 被挂起，4，5 在 cacheArea 中等待，预取停在 1+workLength
+	assert.Eventually(t, func() bool {
+		stats := tentacle.Stats()
+		return stats.Cached == 2 && len(stats.InFlight) == 1 && stats.InFlight[0].Paused
+	}, time.Second, 5*time.Millisecond)
+	stats = tentacle.Stats()
+	assert.True(t, stats.Started)
+	assert.Equal(t, int64(100), stats.MaxSequence)
+	assert.Equal(t, int64(5), stats.LastInputsSequence)
+	assert.Equal(t, int64(95), stats.Lag())
+	assert.Equal(t, int64(2), stats.LastQueueSequence)
+	assert.Equal(t, int64(1), stats.Prefetched())
+	assert.Equal(t, int64(2), stats.Workers)
+	assert.Len(t, stats.InFlight, 1)
+	oldest, ok := stats.Oldest()
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), oldest.Sequence)
+	assert.Equal(t, 2, oldest.Attempts)
+	assert.True(t, oldest.Paused)
+	assert.Error(t, oldest.Err)
+	assert.Equal(t, int64(2), failures.Load())
+	assert.Equal(t, int64(2), completed.Load())
+
+	healed.Store(true)
+	tentacle.Resume()
+	for i := int64(2); i <= 10; i++ {
+		_, err = tentacle.Get(i)
+		assert.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, completed.Load(), int64(10))
+	assert.Equal(t, completed.Load()+failures.Load(), results.Load())
+}
+
+func TestTentacleStore(t *testing.T) {
+	store, err := NewFileStore[mint64](t.TempDir())
+	assert.NoError(t, err)
+
+	var handled sync.Map
+	factory := testNewFactory(func(salt, sequence int64) (mint64, error) {
+		handled.Store(sequence, true)
+		return mint64(salt + sequence), nil
+	})
+	stored := func(sequence int64) bool {
+		values, err := store.LoadFrom(sequence)
+		assert.NoError(t, err)
+		_, ok := values[sequence]
+		return ok
+	}
+	getAll := func(tentacle *Tentacle[mint64], from, to int64) {
+		for i := from; i <= to; i++ {
+			value, err := tentacle.Get(i)
+			assert.NoError(t, err)
+			assert.Equal(t, mint64(110+i), value)
+		}
+	}
+
+	tentacle := NewTentacle(2, 2, 2, factory).SetStore(store)
+	assert.NoError(t, tentacle.UpdateMaxSequence(8))
+	getAll(tentacle, 1, 5)
+	// 等待预取完成
+	assert.Eventually(t, func() bool {
+		return stored(6) && stored(7) && stored(8)
+	}, time.Second, 5*time.Millisecond)
+	tentacle.Stop()
+
+	// 保留区之前的结果已被清理，每移出 workLength 个清理一次
+	assert.False(t, stored(3))
+	assert.True(t, stored(4))
+
+	// 重启后直接使用 store 中的结果
+	handled.Clear()
+	tentacle = NewTentacle(2, 2, 2, factory).SetStore(store)
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(12))
+	getAll(tentacle, 4, 12)
+	for i := int64(4); i <= 8; i++ {
+		_, ok := handled.Load(i)
+		assert.False(t, ok, i)
+	}
+	_, ok := handled.Load(int64(9))
+	assert.True(t, ok)
+
+	// Rewind 作废 store 中的结果
+	handled.Clear()
+	assert.NoError(t, tentacle.Rewind(10))
+	assert.False(t, stored(11))
+	getAll(tentacle, 11, 12)
+	_, ok = handled.Load(int64(11))
+	assert.True(t, ok)
+}
+
+func TestCollectBatchCarry(t *testing.T) {
+	inputs := make(chan int64, 4)
+	carry := []int64{5, 9, 6, 12}
+	batch, carry := takeCarry(carry, 4)
+	batch, carry = collectBatch(batch, carry, inputs, 4)
+	assert.Equal(t, []int64{5, 6}, batch)
+	assert.Equal(t, []int64{9, 12}, carry)
+
+	inputs <- 10
+	inputs <- 13
+	batch, carry = takeCarry(carry, 4)
+	batch, carry = collectBatch(batch, carry, inputs, 4)
+	assert.Equal(t, []int64{9, 10}, batch)
+	assert.Equal(t, []int64{12, 13}, carry)
+
+	batch, carry = takeCarry(carry, 4)
+	batch, carry = collectBatch(batch, carry, inputs, 4)
+	assert.Equal(t, []int64{12, 13}, batch)
+	assert.Empty(t, carry)
+}
+
+func TestTentacleBatchWorker(t *testing.T) {
+	var batches, largest atomic.Int64
+	var sevenFailed atomic.Bool
+	factory := NewBatchWorkerFactory(func() (tree.Context, error) {
+		return tree.NewDefaultContext(), nil
+	}, 5, func(_ tree.Context, sequences []int64) ([]mint64, []error, error) {
+		batches.Add(1)
+		if n := int64(len(sequences)); n > largest.Load() {
+			largest.Store(n)
+		}
+		results := make([]mint64, len(sequences))
+		errs := make([]error, len(sequences))
+		for i, sequence := range sequences {
+			results[i] = mint64(sequence * 2)
+			if sequence == 7 && sevenFailed.CompareAndSwap(false, true) {
+				// 部分失败，只有 7 需要重试
+				errs[i] = errors.New("seven")
+			}
+		}
+		return results, errs, nil
+	})
+
+	tentacle := NewTentacle(2, 10, 2, factory).
+		SetRetry(0, ExponentialBackoff(time.Millisecond, time.Millisecond))
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(100))
+	for i := int64(1); i <= 100; i++ {
+		value, err := tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(i*2), value)
+	}
+	assert.True(t, sevenFailed.Load())
+	assert.Equal(t, int64(5), largest.Load())
+	assert.Less(t, batches.Load(), int64(100))
+}
+
+func TestTentacleStream(t *testing.T) {
+	var calls atomic.Int64
+	var healed atomic.Bool
+	tentacle := NewTentacle(2, 2, 2, testPoisonFactory(7, &calls, &healed)).SetRetry(1, nil)
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(5))
+	time.AfterFunc(20*time.Millisecond, func() {
+		assert.NoError(t, tentacle.UpdateMaxSequence(12))
+	})
+
+	expected := int64(1)
+	for item, err := range tentacle.Stream(context.Background(), 1) {
+		assert.Equal(t, expected, item.Sequence)
+		if item.Sequence == 7 || item.Sequence == 10 {
+			// 错误在迭代中返回，之后继续(testNewFactory 中 10 会失败一次)
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, mint64(110+item.Sequence), item.Value)
+		}
+		if item.Sequence == 10 {
+			break
+		}
+		expected++
+	}
+	assert.Equal(t, int64(10), expected)
+
+	// 等待 maxSequence 时 ctx 结束
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var last error
+	count := 0
+	for _, err := range tentacle.Stream(ctx, 11) {
+		last = err
+		count++
+	}
+	assert.Equal(t, 3, count)
+	assert.ErrorIs(t, last, context.DeadlineExceeded)
+
+	// 等待期间被 Stop
+	time.AfterFunc(20*time.Millisecond, tentacle.Stop)
+	for item, err := range tentacle.Stream(context.Background(), 13) {
+		assert.Equal(t, int64(13), item.Sequence)
+		assert.ErrorIs(t, err, ErrStopped)
+	}
+}
+
+func TestTentacleThen(t *testing.T) {
+	var live, running, busiest atomic.Int64
+	var fiveFailed atomic.Bool
+	decode := func(sequence int64, in mint64) (mint64, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := busiest.Load()
+			if n <= old || busiest.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		if sequence == 5 && fiveFailed.CompareAndSwap(false, true) {
+			return 0, errors.New("decode")
+		}
+		return in * 10, nil
+	}
+	apply := func(_ int64, in mint64) (mint64, error) {
+		return in + 1, nil
+	}
+	factory := Then(Then(testCountingFactory(&live, 0), 2, decode), 1, apply)
+
+	tentacle := NewTentacle(4, 4, 2, factory).SetRetry(3, nil)
+	assert.NoError(t, tentacle.UpdateMaxSequence(60))
+	for i := int64(1); i <= 60; i++ {
+		value, err := tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(i*10+1), value)
+	}
+	assert.True(t, fiveFailed.Load())
+	assert.Equal(t, int64(2), busiest.Load())
+
+	tentacle.Stop()
+	assert.Equal(t, int64(0), live.Load())
+}
+
+func TestTentacleHealth(t *testing.T) {
+	var generation atomic.Int64
+	nextClient := func() (tree.Context, error) {
+		ctx := tree.NewDefaultContext()
+		ctx.Set("generation", generation.Add(1))
+		return ctx, nil
+	}
+	var replaced atomic.Int64
+	factory := NewWorkerFactoryWithHealth(nextClient, func(ctx tree.Context, sequence int64) (mint64, error) {
+		g, err := tree.Get[int64](ctx, "generation")
+		if err != nil {
+			return 0, err
+		}
+		if g == 1 {
+			return 0, errors.New("bad node")
+		}
+		return mint64(sequence), nil
+	}, Health{
+		MaxFailures: 1,
+		OnReplace: func(event ReplaceEvent) {
+			assert.Equal(t, int64(1), event.Worker)
+			assert.NoError(t, event.NewErr)
+			replaced.Add(1)
+		},
+	})
+
+	tentacle := NewTentacle(1, 2, 2, factory)
+	defer tentacle.Stop()
+	assert.NoError(t, tentacle.UpdateMaxSequence(5))
+	for i := int64(1); i <= 5; i++ {
+		value, err := tentacle.Get(i)
+		assert.NoError(t, err)
+		assert.Equal(t, mint64(i), value)
+	}
+	assert.Equal(t, int64(1), replaced.Load())
+	assert.Equal(t, int64(2), generation.Load())
+}
@@ -1,11 +1,14 @@
 package tentacle
 
 import (
-	"reflect"
-	"runtime"
-	"strings"
+	"context"
+	"slices"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/LukeEuler/dolly/common/internal/worker"
 	"github.com/LukeEuler/dolly/common/tree"
 	"github.com/LukeEuler/dolly/log"
 )
@@ -17,14 +20,47 @@ type Cloner[T any] interface {
 // Factory 是 Worker 的构造器接口方法，同时也是为了方便隐藏业务的初始化变量
 type Factory[T Cloner[T]] func() (Worker[T], error)
 
-// Worker 用于处理具体业务对接口方法
-type Worker[T Cloner[T]] func(inputs chan int64, outputs chan *box[T])
+/*
+Worker 用于处理具体业务对接口方法
+
+ctx 结束后 worker 需要尽快退出，不再从 inputs 中领取任务
+已经领取的任务，其结果仍需发送到 outputs（tentacle 会一直读取 outputs 直至所有 worker 退出）
+*/
+type Worker[T Cloner[T]] func(ctx context.Context, inputs chan int64, outputs chan *box[T])
+
+// Backoff 返回第 attempts 次失败后，重新分发前的等待时间
+type Backoff func(attempts int) time.Duration
+
+func ConstantBackoff(wait time.Duration) Backoff {
+	return func(int) time.Duration {
+		return wait
+	}
+}
+
+// ExponentialBackoff base, 2*base, 4*base ... 直至 maxWait
+func ExponentialBackoff(base, maxWait time.Duration) Backoff {
+	return worker.ExponentialBackoff(base, maxWait)
+}
+
+// FailureAction sequence 达到最大尝试次数后的处理方式
+type FailureAction int
+
+const (
+	FailureFail  FailureAction = iota // Get 该 sequence 时返回最终的错误
+	FailureSkip                       // 以零值作为该 sequence 的结果，Get 不返回错误
+	FailurePause                      // 挂起该 sequence，不再分发，直到调用 Resume
+	FailureRetry                      // 失败次数清零，继续重试
+)
+
+// FailureHandler 在 sequence 达到最大尝试次数时调用，决定如何处理
+type FailureHandler func(sequence int64, attempts int, err error) FailureAction
 
 // box 是 Worker 处理后的数据结果
 type box[T Cloner[T]] struct {
 	sequence int64
 	result   T
 	err      error
+	cost     time.Duration // worker 处理耗时，未统计时为 0
 }
 
 /*
@@ -38,55 +74,213 @@ ITentacle 是一个针对增量序列(非严格增量)顺序处理，的多协
 假设 处理 A，B 耗时分别是 Ta, Tb。
 那么，顺序处理的总耗时 T = Ta1+Tb1+Ta2+Tb2...。
 优化后，耗时最少可以减少到 T = Ta1+Tb1+Tb2...
+A 还可以继续拆分为多个并行阶段(A1->A2->...)，见 Then
 
 我们假定：
 
 	当外部调用处理了某序列 s 后，不会出现小于 s 的处理，（但可能会多次处理 s）
 	更严格来说：如果某次处理的序列为 s 时，那么下次的处理序列，要么是 s，要么是 s+1
+	唯一的例外是 Rewind(r)：之后的处理序列需要 <= r+1
 */
 type ITentacle[T Cloner[T]] interface {
-	UpdateMaxSequence(sequence int64) error // 规定当前的最大处理序列
-	Get(sequence int64) (T, error)          // 按序列获取数据
-	Stop()                                  // 将 Tentacle 恢复到初始状态
+	Start(ctx context.Context, sequence int64) error           // 以 ctx 为生命周期，从 sequence 开始预取
+	UpdateMaxSequence(sequence int64) error                    // 规定当前的最大处理序列
+	Get(sequence int64) (T, error)                             // 按序列获取数据
+	GetContext(ctx context.Context, sequence int64) (T, error) // 按序列获取数据，ctx 结束时返回
+	Rewind(toSequence int64) error                             // 作废大于 toSequence 的结果，并重新预取
+	Stop()                                                     // 停止所有 worker，将 Tentacle 恢复到初始状态
 }
 
 func NewWorkerFactory[T Cloner[T]](
 	nextClient func() (tree.Context, error),
 	f func(tree.Context, int64) (T, error)) Factory[T] {
-	funcName := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
-	list := strings.Split(funcName, "/")
-	if len(list) > 0 {
-		funcName = list[len(list)-1]
-	}
+	return NewWorkerFactoryWithHealth(nextClient, f, Health{})
+}
+
+// NewWorkerFactoryWithHealth 同 NewWorkerFactory，worker 连续失败过多时，通过 nextClient 替换 client
+func NewWorkerFactoryWithHealth[T Cloner[T]](
+	nextClient func() (tree.Context, error),
+	f func(tree.Context, int64) (T, error),
+	health Health) Factory[T] {
+	funcName := worker.FuncName(f)
 
+	var counter atomic.Int64
 	return func() (Worker[T], error) {
-		ctx, err := nextClient()
+		hc, err := worker.NewHealthClient("tentacle", &counter, nextClient, health)
 		if err != nil {
 			return nil, err
 		}
-		return func(inputs chan int64, outputs chan *box[T]) {
+		return func(wctx context.Context, inputs chan int64, outputs chan *box[T]) {
 			for {
-				height, ok := <-inputs
-				if !ok {
-					// inputs 被关闭了, worker 就需要停下
-					break
+				if wctx.Err() != nil {
+					return
+				}
+				var height int64
+				var ok bool
+				select {
+				case <-wctx.Done():
+					return
+				case height, ok = <-inputs:
+					if !ok {
+						// inputs 被关闭了, worker 就需要停下
+						return
+					}
 				}
 				log.Entry.WithField("tentacle", funcName).Infof("try get %d", height)
 				start := time.Now()
-				res, err := f(ctx, height)
+				res, err := f(hc.Client(), height)
+				hc.Report(err)
+				cost := time.Since(start)
 				log.Entry.WithField("tentacle", funcName).
-					WithField("cost", time.Since(start).String()).
+					WithField("cost", cost.String()).
 					Infof("%d done", height)
-				if err != nil {
-					// 防止程序在错误上，过多的浪费资源。主要是错误日志会爆
-					time.Sleep(time.Second)
-				}
 				outputs <- &box[T]{
 					sequence: height,
 					result:   res,
 					err:      err,
+					cost:     cost,
 				}
 			}
 		}, nil
 	}
 }
+
+/*
+NewBatchWorkerFactory 批量模式，每次从 inputs 中领取至多 batchSize 个连续的 sequence，一起交给 f 处理
+适用于可以批量请求的场景，例如 rpc.Client.BatchSyncCall
+
+f 返回与 sequences 一一对应的结果及错误，errs 可以为 nil；返回 err 时视为所有 sequence 都失败
+失败的 sequence 会被单独重试(可能和其他 sequence 组成新的批次)，成功的结果依然按顺序交给 Get
+
+为了能凑满批次，workLength(concurrent * redundancy) 最好不小于 concurrent * batchSize
+*/
+func NewBatchWorkerFactory[T Cloner[T]](
+	nextClient func() (tree.Context, error),
+	batchSize int,
+	f func(ctx tree.Context, sequences []int64) (results []T, errs []error, err error)) Factory[T] {
+	return NewBatchWorkerFactoryWithHealth(nextClient, batchSize, f, Health{})
+}
+
+// NewBatchWorkerFactoryWithHealth 同 NewBatchWorkerFactory，整个批次都失败时才计为一次失败
+func NewBatchWorkerFactoryWithHealth[T Cloner[T]](
+	nextClient func() (tree.Context, error),
+	batchSize int,
+	f func(ctx tree.Context, sequences []int64) (results []T, errs []error, err error),
+	health Health) Factory[T] {
+	funcName := worker.FuncName(f)
+	batchSize = max(batchSize, 1)
+
+	var counter atomic.Int64
+	return func() (Worker[T], error) {
+		hc, err := worker.NewHealthClient("tentacle", &counter, nextClient, health)
+		if err != nil {
+			return nil, err
+		}
+		return func(wctx context.Context, inputs chan int64, outputs chan *box[T]) {
+			// carry 为上一批次中领取的、不连续的 sequence，已领取的任务必须处理
+			var carry []int64
+			for {
+				var batch []int64
+				if len(carry) > 0 {
+					batch, carry = takeCarry(carry, batchSize)
+				} else {
+					if wctx.Err() != nil {
+						return
+					}
+					select {
+					case <-wctx.Done():
+						return
+					case height, ok := <-inputs:
+						if !ok {
+							return
+						}
+						batch = []int64{height}
+					}
+				}
+				batch, carry = collectBatch(batch, carry, inputs, batchSize)
+
+				log.Entry.WithField("tentacle", funcName).Infof("try get %d-%d", batch[0], batch[len(batch)-1])
+				start := time.Now()
+				results, errs, err := f(hc.Client(), batch)
+				cost := time.Since(start)
+				log.Entry.WithField("tentacle", funcName).
+					WithField("cost", cost.String()).
+					Infof("%d-%d done", batch[0], batch[len(batch)-1])
+				if err == nil && (len(results) != len(batch) || (errs != nil && len(errs) != len(batch))) {
+					err = errors.Errorf("batch %d-%d: got %d results and %d errors for %d sequences",
+						batch[0], batch[len(batch)-1], len(results), len(errs), len(batch))
+				}
+
+				items := make([]*box[T], 0, len(batch))
+				// 整个批次都失败时，batchErr 为最后一个错误
+				var batchErr error
+				for i, height := range batch {
+					item := &box[T]{
+						sequence: height,
+						cost:     cost / time.Duration(len(batch)),
+					}
+					switch {
+					case err != nil:
+						item.err = err
+					case errs != nil && errs[i] != nil:
+						item.err = errs[i]
+					default:
+						item.result = results[i]
+					}
+					if i == 0 || batchErr != nil {
+						batchErr = item.err
+					}
+					items = append(items, item)
+				}
+				// 重试前的等待由 Tentacle 的 SetRetry 控制
+				hc.Report(batchErr)
+				for _, item := range items {
+					outputs <- item
+				}
+			}
+		}, nil
+	}
+}
+
+// takeCarry 取出 carry 中的第一个 sequence 作为新的批次，batch 使用新的底层数组，之后的 append 不会覆盖 carry
+func takeCarry(carry []int64, batchSize int) ([]int64, []int64) {
+	batch := make([]int64, 1, batchSize)
+	batch[0] = carry[0]
+	return batch, carry[1:]
+}
+
+// collectBatch 在不阻塞的情况下，从 carry 和 inputs 中继续领取连续的 sequence，遇到不连续的放入 carry 后结束
+func collectBatch(batch, carry []int64, inputs chan int64, batchSize int) ([]int64, []int64) {
+	for len(batch) < batchSize {
+		next := batch[len(batch)-1] + 1
+		if index := slices.Index(carry, next); index >= 0 {
+			carry = slices.Delete(carry, index, index+1)
+			batch = append(batch, next)
+			continue
+		}
+		select {
+		case height, ok := <-inputs:
+			if !ok {
+				return batch, carry
+			}
+			if height != next {
+				// 通常是重试的 sequence，留给下一批次，避免一个 worker 领取过多的任务
+				return batch, append(carry, height)
+			}
+			batch = append(batch, height)
+		default:
+			return batch, carry
+		}
+	}
+	return batch, carry
+}
+
+// sleep 等待 d, ctx 结束时提前返回
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+)
+
+// ErrBodyTooLarge 返回体(解压后)超出了 SetMaxBodySize 设置的大小
+var ErrBodyTooLarge = errors.New("http response body too large")
+
+// acceptEncoding 开启 compression 时请求携带的 Accept-Encoding
+const acceptEncoding = "gzip, deflate, br"
+
+/*
+decodeBody 根据 Content-Encoding 对返回体解压
+
+只在开启 compression 时解压, 否则原样返回:
+未主动设置 Accept-Encoding 时, http.Transport 会自行处理 gzip 并删除 Content-Encoding,
+其余的 Content-Encoding 由调用方自行处理
+*/
+func decodeBody(resp *http.Response, compression bool) (io.Reader, error) {
+	if !compression {
+		return resp.Body, nil
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(resp.Body)
+		return reader, errors.WithStack(err)
+	case "deflate":
+		// 标准中 deflate 为 zlib 格式, 但也有不少服务直接返回 raw deflate
+		buffered := bufio.NewReader(resp.Body)
+		header, err := buffered.Peek(2)
+		if err == nil && isZlibHeader(header) {
+			reader, err := zlib.NewReader(buffered)
+			return reader, errors.WithStack(err)
+		}
+		return flate.NewReader(buffered), nil
+	case "br":
+		return brotli.NewReader(resp.Body), nil
+	}
+	return nil, errors.Errorf("unsupported Content-Encoding: %s", encoding)
+}
+
+func isZlibHeader(h []byte) bool {
+	return h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0
+}
+
+// readBody 解压并读取返回体, maxSize <= 0 时不限制大小
+func readBody(resp *http.Response, compression bool, maxSize int64) ([]byte, error) {
+	reader, err := decodeBody(resp, compression)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 {
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, wrapContextErr(resp.Request.Context(), err)
+	}
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		return nil, errors.WithStack(fmt.Errorf("%w: limit %d bytes", ErrBodyTooLarge, maxSize))
+	}
+	return body, nil
+}
+
+func statusError(statusCode int, bodyBytes []byte) error {
+	bodyStr := string(bodyBytes)
+	if len(bodyBytes) > 500 {
+		bodyStr = string(bodyBytes[:150])
+		bodyStr = strings.ToValidUTF8(bodyStr, "") + "   凸(゜皿゜メ)"
+	}
+	return errors.Errorf("http status %d != 200\n%s", statusCode, bodyStr)
+}
+
+/*
+StreamArray 以流的方式解析返回的 json 数组, 对每个元素调用 fn, 用于返回内容很大的列表接口
+
+key 为空时, 返回体本身为数组; 否则数组为返回体(object)中 key 对应的值, 之前的其他字段逐个 token 跳过
+fn 返回 error 时停止解析, 并返回该 error
+流式解析不经过 SetResultHandler 设置的 handler, 也不受 SetMaxBodySize 的限制
+*/
+func StreamArray[E any](ctx context.Context, s *SimpleJSON, tail, key string, fn func(E) error,
+	params ...QueryParameter) error {
+	req, err := s.newGetRequest(ctx, tail, params)
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(req, nil)
+	if err != nil {
+		return err
+	}
+	// nolint
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, 501))
+		if err != nil {
+			return wrapContextErr(ctx, err)
+		}
+		return statusError(resp.StatusCode, bodyBytes)
+	}
+
+	reader, err := decodeBody(resp, s.compression)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(reader)
+	if key != "" {
+		err = seekKey(decoder, key)
+		if err != nil {
+			return wrapContextErr(ctx, err)
+		}
+	}
+	err = expectDelim(decoder, '[')
+	if err != nil {
+		return wrapContextErr(ctx, err)
+	}
+	for decoder.More() {
+		var item E
+		err = decoder.Decode(&item)
+		if err != nil {
+			return wrapContextErr(ctx, err)
+		}
+		err = fn(item)
+		if err != nil {
+			return err
+		}
+	}
+	return wrapContextErr(ctx, expectDelim(decoder, ']'))
+}
+
+// seekKey 将 decoder 移动到顶层 object 中 key 对应的值之前, 跳过其余的字段
+func seekKey(decoder *json.Decoder, key string) error {
+	err := expectDelim(decoder, '{')
+	if err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if name, ok := token.(string); ok && name == key {
+			return nil
+		}
+		err = skipValue(decoder)
+		if err != nil {
+			return err
+		}
+	}
+	return errors.Errorf("key %s not found", key)
+}
+
+// skipValue 逐个 token 跳过下一个值, 不会将整个值读入内存
+func skipValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return errors.Errorf("expect %s, got %v", delim, token)
+	}
+	return nil
+}
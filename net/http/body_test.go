@@ -0,0 +1,126 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func compress(encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	}
+	_, _ = w.Write(body)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func TestCompression(t *testing.T) {
+	body := []byte(`{"data":"` + strings.Repeat("a", 1000) + `"}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, acceptEncoding, r.Header.Get("Accept-Encoding"))
+		encoding := r.URL.Query().Get("encoding")
+		w.Header().Set("Content-Encoding", encoding)
+		_, _ = w.Write(compress(encoding, body))
+	}))
+	defer server.Close()
+
+	client := NewSimpleJSON(server.URL).SetCompression(true)
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		out := make(map[string]string)
+		err := client.Get("/", &out, QueryParameter{Key: "encoding", Value: encoding})
+		assert.NoError(t, err, encoding)
+		assert.Len(t, out["data"], 1000, encoding)
+	}
+
+	// 限制针对解压后的大小
+	out := make(map[string]string)
+	err := client.SetMaxBodySize(500).Get("/", &out, QueryParameter{Key: "encoding", Value: "gzip"})
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+
+	err = NewResultJSON(server.URL).SetCompression(true).SetMaxBodySize(500).
+		Get("/?encoding=br", &out)
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+
+	// 未开启 compression 时, 不认识的 Content-Encoding 原样返回
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "custom")
+		_, _ = w.Write(body)
+	}))
+	defer plain.Close()
+	out = make(map[string]string)
+	assert.NoError(t, NewSimpleJSON(plain.URL).Get("/", &out))
+	assert.Len(t, out["data"], 1000)
+}
+
+func TestStreamArray(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/wrapped" {
+			_, _ = w.Write([]byte(`{"total":3,"meta":{"a":[1]},"list":[{"id":1},{"id":2},{"id":3}],"next":""}`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id":1},{"id":2},{"id":3}]`))
+	}))
+	defer server.Close()
+	client := NewSimpleJSON(server.URL).SetMaxBodySize(10)
+
+	type item struct {
+		ID int `json:"id"`
+	}
+	ids := make([]int, 0)
+	err := StreamArray(context.Background(), client, "/", "", func(i item) error {
+		ids = append(ids, i.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ids)
+
+	ids = ids[:0]
+	err = StreamArray(context.Background(), client, "/wrapped", "list", func(i item) error {
+		ids = append(ids, i.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ids)
+
+	stop := errors.New("stop")
+	err = StreamArray(context.Background(), client, "/", "", func(i item) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+
+	err = StreamArray(context.Background(), client, "/wrapped", "missing", func(i item) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestSeekKey(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(
+		`{"a":1,"b":"x","c":null,"d":{"e":[1,{"f":[]}],"g":{}},"h":[[],[2]],"list":[3],"i":true}`))
+	assert.NoError(t, seekKey(decoder, "list"))
+	var list []int
+	assert.NoError(t, decoder.Decode(&list))
+	assert.Equal(t, []int{3}, list)
+
+	decoder = json.NewDecoder(strings.NewReader(`{"a":{"list":[1]},"b":[{"list":[2]}]}`))
+	assert.Error(t, seekKey(decoder, "list"))
+}
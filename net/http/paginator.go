@@ -0,0 +1,187 @@
+package http
+
+import (
+	"context"
+	"iter"
+	"strconv"
+)
+
+// PageMode 分页方式
+type PageMode int
+
+const (
+	PageByNumber PageMode = iota // 按页码翻页, 如 ?page=1
+	PageByOffset                 // 按偏移量翻页, 如 ?offset=0, 偏移量按已获取的条目数累加
+	PageByCursor                 // 按 cursor 翻页, 如 ?cursor=xxx, cursor 由上一页的返回结果给出
+)
+
+/*
+PageExtractor 从单页的返回结果中抽取数据
+
+items 为空时认为已经到了最后一页
+next 仅在 PageByCursor 下使用, 为空时同样认为已经到了最后一页
+*/
+type PageExtractor[P any, E any] func(page *P) (items []E, next string)
+
+/*
+Paginator 针对 SimpleJSON.Get 的分页列表接口, 以迭代器的方式逐条返回数据
+
+example:
+
+	p := http.NewPaginator(client, "/txs", func(page *txPage) ([]*tx, string) {
+		return page.Data, page.NextCursor
+	}, http.QueryParameter{Key: "limit", Value: "50"}).ByCursor("cursor")
+	for item, err := range p.Items() {
+		...
+	}
+*/
+type Paginator[P any, E any] struct {
+	client  *SimpleJSON
+	tail    string
+	params  []QueryParameter
+	extract PageExtractor[P, E]
+
+	mode     PageMode
+	key      string // 页码/偏移量/cursor 对应的 query 参数名
+	first    int    // 起始页码或偏移量
+	prefetch bool   // 在消费当前页时, 并行请求下一页
+}
+
+// NewPaginator 默认按页码翻页, query 参数名为 page, 从第 1 页开始
+func NewPaginator[P any, E any](client *SimpleJSON, tail string, extract PageExtractor[P, E],
+	params ...QueryParameter) *Paginator[P, E] {
+	return &Paginator[P, E]{
+		client:  client,
+		tail:    tail,
+		params:  params,
+		extract: extract,
+		mode:    PageByNumber,
+		key:     "page",
+		first:   1,
+	}
+}
+
+func (p *Paginator[P, E]) ByNumber(key string, first int) *Paginator[P, E] {
+	p.mode = PageByNumber
+	p.key = key
+	p.first = first
+	return p
+}
+
+func (p *Paginator[P, E]) ByOffset(key string, first int) *Paginator[P, E] {
+	p.mode = PageByOffset
+	p.key = key
+	p.first = first
+	return p
+}
+
+// ByCursor 首次请求不携带 cursor 参数
+func (p *Paginator[P, E]) ByCursor(key string) *Paginator[P, E] {
+	p.mode = PageByCursor
+	p.key = key
+	p.first = 0
+	return p
+}
+
+func (p *Paginator[P, E]) SetPrefetch(prefetch bool) *Paginator[P, E] {
+	p.prefetch = prefetch
+	return p
+}
+
+// pageState 描述下一次要请求的页
+type pageState struct {
+	index  int    // 页码或偏移量
+	cursor string // PageByCursor 时使用
+	first  bool
+}
+
+type pageResult[E any] struct {
+	items []E
+	next  pageState
+	last  bool // 已经是最后一页, 不再需要请求 next
+	err   error
+}
+
+// Items 按顺序返回所有条目, 出错时返回一次 error 后结束
+func (p *Paginator[P, E]) Items() iter.Seq2[E, error] {
+	return p.ItemsContext(context.Background())
+}
+
+// ItemsContext 同 Items, ctx 被取消或提前结束循环时, 进行中的请求(包括预取)会被中断
+func (p *Paginator[P, E]) ItemsContext(ctx context.Context) iter.Seq2[E, error] {
+	return func(yield func(E, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var zero E
+		state := pageState{index: p.first, first: true}
+		var pending <-chan pageResult[E]
+		for {
+			var res pageResult[E]
+			if pending != nil {
+				res = <-pending
+				pending = nil
+			} else {
+				res = p.fetch(ctx, state)
+			}
+			if res.err != nil {
+				yield(zero, res.err)
+				return
+			}
+			if len(res.items) == 0 {
+				return
+			}
+			if !res.last && p.prefetch {
+				pending = p.fetchAsync(ctx, res.next)
+			}
+			for _, item := range res.items {
+				if !yield(item, nil) {
+					// 预取的请求由 cancel 中断, 其结果通过带缓冲的 channel 返回, 放弃即可
+					return
+				}
+			}
+			if res.last {
+				return
+			}
+			state = res.next
+		}
+	}
+}
+
+func (p *Paginator[P, E]) fetchAsync(ctx context.Context, state pageState) <-chan pageResult[E] {
+	ch := make(chan pageResult[E], 1)
+	go func() {
+		ch <- p.fetch(ctx, state)
+	}()
+	return ch
+}
+
+func (p *Paginator[P, E]) fetch(ctx context.Context, state pageState) pageResult[E] {
+	params := make([]QueryParameter, 0, len(p.params)+1)
+	params = append(params, p.params...)
+	switch p.mode {
+	case PageByCursor:
+		if !state.first {
+			params = append(params, QueryParameter{Key: p.key, Value: state.cursor})
+		}
+	default:
+		params = append(params, QueryParameter{Key: p.key, Value: strconv.Itoa(state.index)})
+	}
+
+	page := new(P)
+	err := p.client.GetContext(ctx, p.tail, page, params...)
+	if err != nil {
+		return pageResult[E]{err: err}
+	}
+	items, next := p.extract(page)
+	res := pageResult[E]{items: items}
+	switch p.mode {
+	case PageByNumber:
+		res.next = pageState{index: state.index + 1}
+	case PageByOffset:
+		res.next = pageState{index: state.index + len(items)}
+	case PageByCursor:
+		res.next = pageState{cursor: next}
+		res.last = next == ""
+	}
+	return res
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPage struct {
+	Data []int  `json:"data"`
+	Next string `json:"next"`
+}
+
+func testPageServer(total, size int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		start := 0
+		switch {
+		case q.Has("page"):
+			page, _ := strconv.Atoi(q.Get("page"))
+			start = (page - 1) * size
+		case q.Has("offset"):
+			start, _ = strconv.Atoi(q.Get("offset"))
+		case q.Has("cursor"):
+			start, _ = strconv.Atoi(q.Get("cursor"))
+		}
+		page := testPage{Data: []int{}}
+		for i := start; i < total && i < start+size; i++ {
+			page.Data = append(page.Data, i)
+		}
+		if start+size < total {
+			page.Next = strconv.Itoa(start + size)
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+}
+
+func testExtract(page *testPage) ([]int, string) {
+	return page.Data, page.Next
+}
+
+func collect(t *testing.T, p *Paginator[testPage, int]) []int {
+	result := make([]int, 0)
+	for item, err := range p.Items() {
+		assert.NoError(t, err)
+		result = append(result, item)
+	}
+	return result
+}
+
+func TestPaginator(t *testing.T) {
+	server := testPageServer(23, 5)
+	defer server.Close()
+	client := NewSimpleJSON(server.URL)
+
+	expected := make([]int, 0, 23)
+	for i := 0; i < 23; i++ {
+		expected = append(expected, i)
+	}
+
+	p := NewPaginator(client, "/list", testExtract)
+	assert.Equal(t, expected, collect(t, p))
+
+	p = NewPaginator(client, "/list", testExtract).ByOffset("offset", 0)
+	assert.Equal(t, expected, collect(t, p))
+
+	p = NewPaginator(client, "/list", testExtract).ByCursor("cursor")
+	assert.Equal(t, expected, collect(t, p))
+
+	p = NewPaginator(client, "/list", testExtract).ByCursor("cursor").SetPrefetch(true)
+	assert.Equal(t, expected, collect(t, p))
+
+	// 提前结束
+	p = NewPaginator(client, "/list", testExtract).SetPrefetch(true)
+	count := 0
+	for _, err := range p.Items() {
+		assert.NoError(t, err)
+		count++
+		if count == 7 {
+			break
+		}
+	}
+	assert.Equal(t, 7, count)
+}
+
+func TestPaginatorPrefetchCancel(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			// 预取的请求一直等待, 直到被中断
+			close(started)
+			<-r.Context().Done()
+			close(cancelled)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(testPage{Data: []int{1, 2, 3}, Next: "2"})
+	}))
+	defer server.Close()
+
+	p := NewPaginator(NewSimpleJSON(server.URL), "/list", testExtract).SetPrefetch(true)
+	for _, err := range p.Items() {
+		assert.NoError(t, err)
+		<-started
+		break
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("prefetch request is not cancelled")
+	}
+}
+
+func TestPaginatorError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	p := NewPaginator(NewSimpleJSON(server.URL), "/list", testExtract)
+	errCount := 0
+	for _, err := range p.Items() {
+		assert.Error(t, err)
+		errCount++
+	}
+	assert.Equal(t, 1, errCount)
+}
@@ -2,10 +2,9 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,6 +16,9 @@ import (
 type ResultJSON struct {
 	client *http.Client
 	url    string
+
+	maxBodySize int64 // 返回体(解压后)的最大字节数, <= 0 时不限制
+	compression bool  // 主动请求 gzip/deflate/br 压缩
 }
 
 func NewResultJSON(url string) *ResultJSON {
@@ -43,6 +45,7 @@ func (r *result) GetError() error {
 	return errors.New(r.Error)
 }
 
+// SetTimeout 设置 client 级别的超时, 单次请求的超时请使用 context.WithTimeout 配合 XxxContext 方法
 func (r *ResultJSON) SetTimeout(timeout time.Duration) *ResultJSON {
 	r.client.Timeout = timeout
 	return r
@@ -52,58 +55,81 @@ func (r ResultJSON) SetTransport(ts http.RoundTripper) {
 	r.client.Transport = ts
 }
 
+// SetMaxBodySize 超出时返回 ErrBodyTooLarge, <= 0 时不限制
+func (r *ResultJSON) SetMaxBodySize(size int64) *ResultJSON {
+	r.maxBodySize = size
+	return r
+}
+
+// SetCompression 开启后请求携带 Accept-Encoding: gzip, deflate, br, 并自行解压返回体
+func (r *ResultJSON) SetCompression(compression bool) *ResultJSON {
+	r.compression = compression
+	return r
+}
+
 func (r *ResultJSON) Get(tail string, object any) error {
-	req, err := http.NewRequest("GET", r.url+tail, nil)
+	return r.GetContext(context.Background(), tail, object)
+}
+
+func (r *ResultJSON) GetContext(ctx context.Context, tail string, object any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", r.url+tail, nil)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	if r.compression {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
 	command, _ := common.GetCurlCommand(req)
 	log.Entry.WithField("tags", "request").Debug(command)
 
 	resp, err := r.client.Do(req)
 	if err != nil {
-		return errors.WithStack(err)
+		return wrapContextErr(ctx, err)
 	}
-	return handleResponse(resp, object)
+	return handleResponse(resp, object, r.compression, r.maxBodySize)
 }
 
 func (r *ResultJSON) Post(tail string, in, out any) error {
+	return r.PostContext(context.Background(), tail, in, out)
+}
+
+func (r *ResultJSON) PostContext(ctx context.Context, tail string, in, out any) error {
 	marshal, err := json.Marshal(in)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	req, err := http.NewRequest("POST", r.url+tail, bytes.NewReader(marshal))
+	req, err := http.NewRequestWithContext(ctx, "POST", r.url+tail, bytes.NewReader(marshal))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	if r.compression {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
 	command, _ := common.GetCurlCommand(req)
 	log.Entry.WithField("tags", "request").Debug(command)
 
 	resp, err := r.client.Do(req)
 	if err != nil {
-		return errors.WithStack(err)
+		return wrapContextErr(ctx, err)
 	}
-	return handleResponse(resp, out)
+	return handleResponse(resp, out, r.compression, r.maxBodySize)
 }
 
-func handleResponse(resp *http.Response, out any) error {
+func handleResponse(resp *http.Response, out any, compression bool, maxBodySize int64) error {
 	// nolint
 	defer resp.Body.Close()
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readBody(resp, compression, maxBodySize)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 	if resp.StatusCode != 200 {
-		bodyStr := string(bodyBytes)
-		if len(bodyBytes) > 500 {
-			bodyStr = string(bodyBytes[:150])
-			bodyStr = strings.ToValidUTF8(bodyStr, "") + "   凸(゜皿゜メ)"
-		}
-		return errors.Errorf("http status %d != 200\n%s", resp.StatusCode, bodyStr)
+		return statusError(resp.StatusCode, bodyBytes)
 	}
 	return unmarshalBody(bodyBytes, out)
 }
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+/*
+RequestSigner 在请求发出前, 对请求做签名, 通常是添加签名相关的 header
+
+body 为请求体的原始内容, 没有请求体时为 nil
+SignedHeaders 返回的 header 会在 debug 日志(curl 命令)中被隐藏
+*/
+type RequestSigner interface {
+	Sign(req *http.Request, body []byte) error
+	SignedHeaders() []string
+}
+
+// SignPayload 参与签名的请求内容
+type SignPayload struct {
+	Timestamp string // 毫秒时间戳
+	Nonce     string // 未设置 nonce header 时为空
+	Method    string
+	Path      string // 包含 query, 如 /api/v5/order?instId=BTC-USDT
+	Body      []byte
+}
+
+// DefaultSignPayload timestamp + nonce + method + path + body
+func DefaultSignPayload(p SignPayload) []byte {
+	payload := make([]byte, 0, len(p.Timestamp)+len(p.Nonce)+len(p.Method)+len(p.Path)+len(p.Body))
+	payload = append(payload, p.Timestamp...)
+	payload = append(payload, p.Nonce...)
+	payload = append(payload, p.Method...)
+	payload = append(payload, p.Path...)
+	payload = append(payload, p.Body...)
+	return payload
+}
+
+// signerBase HMACSigner 和 Ed25519Signer 共用的 header 及 payload 处理
+type signerBase struct {
+	key string
+
+	keyHeader       string
+	signatureHeader string
+	timestampHeader string
+	nonceHeader     string // 为空时不生成 nonce
+
+	payload  func(SignPayload) []byte
+	encoding func([]byte) string
+	now      func() time.Time
+}
+
+func newSignerBase(key string, encoding func([]byte) string) signerBase {
+	return signerBase{
+		key:             key,
+		keyHeader:       "X-API-KEY",
+		signatureHeader: "X-SIGNATURE",
+		timestampHeader: "X-TIMESTAMP",
+		payload:         DefaultSignPayload,
+		encoding:        encoding,
+		now:             time.Now,
+	}
+}
+
+func (b *signerBase) sign(req *http.Request, body []byte, sum func([]byte) []byte) error {
+	p := SignPayload{
+		Timestamp: strconv.FormatInt(b.now().UnixMilli(), 10),
+		Method:    req.Method,
+		Path:      req.URL.RequestURI(),
+		Body:      body,
+	}
+	if b.nonceHeader != "" {
+		p.Nonce = uuid.NewV4().String()
+		req.Header.Set(b.nonceHeader, p.Nonce)
+	}
+	if b.keyHeader != "" {
+		req.Header.Set(b.keyHeader, b.key)
+	}
+	if b.timestampHeader != "" {
+		req.Header.Set(b.timestampHeader, p.Timestamp)
+	}
+	req.Header.Set(b.signatureHeader, b.encoding(sum(b.payload(p))))
+	return nil
+}
+
+func (b *signerBase) SignedHeaders() []string {
+	if b.keyHeader == "" {
+		return []string{b.signatureHeader}
+	}
+	return []string{b.keyHeader, b.signatureHeader}
+}
+
+/*
+HMACSigner HMAC-SHA256 签名, 签名结果默认使用 hex 编码
+
+默认 header:
+
+	X-API-KEY: key
+	X-SIGNATURE: hex(hmac_sha256(secret, timestamp + method + path + body))
+	X-TIMESTAMP: timestamp
+*/
+type HMACSigner struct {
+	signerBase
+	secret []byte
+}
+
+func NewHMACSigner(key string, secret []byte) *HMACSigner {
+	return &HMACSigner{
+		signerBase: newSignerBase(key, hex.EncodeToString),
+		secret:     secret,
+	}
+}
+
+func (s *HMACSigner) Sign(req *http.Request, body []byte) error {
+	return s.sign(req, body, func(payload []byte) []byte {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(payload)
+		return mac.Sum(nil)
+	})
+}
+
+// SetHeaders header 名为空时, 不设置该 header(signature 除外)
+func (s *HMACSigner) SetHeaders(keyHeader, signatureHeader, timestampHeader string) *HMACSigner {
+	s.keyHeader = keyHeader
+	s.signatureHeader = signatureHeader
+	s.timestampHeader = timestampHeader
+	return s
+}
+
+func (s *HMACSigner) SetNonceHeader(nonceHeader string) *HMACSigner {
+	s.nonceHeader = nonceHeader
+	return s
+}
+
+func (s *HMACSigner) SetPayload(payload func(SignPayload) []byte) *HMACSigner {
+	s.payload = payload
+	return s
+}
+
+// SetEncoding 例如 base64.StdEncoding.EncodeToString
+func (s *HMACSigner) SetEncoding(encoding func([]byte) string) *HMACSigner {
+	s.encoding = encoding
+	return s
+}
+
+/*
+Ed25519Signer Ed25519 签名, 签名结果默认使用 base64 编码
+
+header 与 HMACSigner 相同
+*/
+type Ed25519Signer struct {
+	signerBase
+	privateKey ed25519.PrivateKey
+}
+
+func NewEd25519Signer(key string, privateKey ed25519.PrivateKey) *Ed25519Signer {
+	return &Ed25519Signer{
+		signerBase: newSignerBase(key, base64.StdEncoding.EncodeToString),
+		privateKey: privateKey,
+	}
+}
+
+func (s *Ed25519Signer) Sign(req *http.Request, body []byte) error {
+	return s.sign(req, body, func(payload []byte) []byte {
+		return ed25519.Sign(s.privateKey, payload)
+	})
+}
+
+func (s *Ed25519Signer) SetHeaders(keyHeader, signatureHeader, timestampHeader string) *Ed25519Signer {
+	s.keyHeader = keyHeader
+	s.signatureHeader = signatureHeader
+	s.timestampHeader = timestampHeader
+	return s
+}
+
+func (s *Ed25519Signer) SetNonceHeader(nonceHeader string) *Ed25519Signer {
+	s.nonceHeader = nonceHeader
+	return s
+}
+
+func (s *Ed25519Signer) SetPayload(payload func(SignPayload) []byte) *Ed25519Signer {
+	s.payload = payload
+	return s
+}
+
+func (s *Ed25519Signer) SetEncoding(encoding func([]byte) string) *Ed25519Signer {
+	s.encoding = encoding
+	return s
+}
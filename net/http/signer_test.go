@@ -0,0 +1,70 @@
+package http
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSignServer(t *testing.T, verify func(r *http.Request, payload []byte) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		payload := DefaultSignPayload(SignPayload{
+			Timestamp: r.Header.Get("X-TIMESTAMP"),
+			Nonce:     r.Header.Get("X-NONCE"),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Body:      body,
+		})
+		if !verify(r, payload) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+}
+
+func TestHMACSigner(t *testing.T) {
+	secret := []byte("secret")
+	server := testSignServer(t, func(r *http.Request, payload []byte) bool {
+		assert.NotEmpty(t, r.Header.Get("X-NONCE"))
+		assert.Equal(t, "key", r.Header.Get("X-API-KEY"))
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		return r.Header.Get("X-SIGNATURE") == hex.EncodeToString(mac.Sum(nil))
+	})
+	defer server.Close()
+
+	client := NewSimpleJSON(server.URL).
+		SetSigner(NewHMACSigner("key", secret).SetNonceHeader("X-NONCE"))
+	out := make(map[string]bool)
+	assert.NoError(t, client.Get("/order", &out, QueryParameter{Key: "id", Value: "1"}))
+	assert.True(t, out["ok"])
+	assert.NoError(t, client.Post("/order", map[string]int{"amount": 1}, &out))
+	assert.NoError(t, client.PostString("/order", `{"amount":2}`, &out))
+}
+
+func TestEd25519Signer(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	server := testSignServer(t, func(r *http.Request, payload []byte) bool {
+		signature, err := base64.StdEncoding.DecodeString(r.Header.Get("X-SIGNATURE"))
+		assert.NoError(t, err)
+		return ed25519.Verify(publicKey, payload, signature)
+	})
+	defer server.Close()
+
+	client := NewSimpleJSON(server.URL).SetSigner(NewEd25519Signer("key", privateKey))
+	out := make(map[string]bool)
+	assert.NoError(t, client.Post("/order", map[string]int{"amount": 1}, &out))
+	assert.True(t, out["ok"])
+}
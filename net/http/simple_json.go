@@ -2,8 +2,8 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -24,6 +24,11 @@ type SimpleJSON struct {
 	headers map[string]string
 
 	handler resultHandler // 用以更灵活的支持各式返回结果,目前仅不支持批量请求，需要时请自行修改BatchSyncCall并充分测试
+
+	signer RequestSigner // 每次请求发出前签名, 为 nil 时不签名
+
+	maxBodySize int64 // 返回体(解压后)的最大字节数, <= 0 时不限制
+	compression bool  // 主动请求 gzip/deflate/br 压缩
 }
 
 func NewSimpleJSON(url string) *SimpleJSON {
@@ -42,6 +47,7 @@ func (s *SimpleJSON) GetURL() string {
 	return s.url
 }
 
+// SetTimeout 设置 client 级别的超时, 单次请求的超时请使用 context.WithTimeout 配合 XxxContext 方法
 func (s *SimpleJSON) SetTimeout(timeout time.Duration) *SimpleJSON {
 	s.client.Timeout = timeout
 	return s
@@ -69,10 +75,40 @@ func (s *SimpleJSON) SetResultHandler(handler resultHandler) *SimpleJSON {
 	return s
 }
 
+// SetSigner 签名在所有 header 设置完成之后进行
+func (s *SimpleJSON) SetSigner(signer RequestSigner) *SimpleJSON {
+	s.signer = signer
+	return s
+}
+
+// SetMaxBodySize 超出时返回 ErrBodyTooLarge, <= 0 时不限制
+func (s *SimpleJSON) SetMaxBodySize(size int64) *SimpleJSON {
+	s.maxBodySize = size
+	return s
+}
+
+// SetCompression 开启后请求携带 Accept-Encoding: gzip, deflate, br, 并自行解压返回体
+func (s *SimpleJSON) SetCompression(compression bool) *SimpleJSON {
+	s.compression = compression
+	return s
+}
+
 func (s *SimpleJSON) Get(tail string, out any, params ...QueryParameter) error {
-	req, err := http.NewRequest("GET", s.url+tail, nil)
+	return s.GetContext(context.Background(), tail, out, params...)
+}
+
+func (s *SimpleJSON) GetContext(ctx context.Context, tail string, out any, params ...QueryParameter) error {
+	req, err := s.newGetRequest(ctx, tail, params)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	return s.do(req, nil, out)
+}
+
+func (s *SimpleJSON) newGetRequest(ctx context.Context, tail string, params []QueryParameter) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url+tail, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 	if len(params) > 0 {
 		q := req.URL.Query()
@@ -82,133 +118,126 @@ func (s *SimpleJSON) Get(tail string, out any, params ...QueryParameter) error {
 		req.URL.RawQuery = q.Encode()
 	}
 
-	if len(s.headers) != 0 {
-		for k, v := range s.headers {
-			req.Header.Set(k, v)
-		}
-	}
-
-	command, _ := common.GetCurlCommand(req)
-	log.Entry.WithField("tags", "request").Debug(command)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	return handleResponseForSimpleJSON(resp, out, s.handler)
+	s.setHeaders(req)
+	return req, nil
 }
 
 func (s *SimpleJSON) GetWithHeader(hKey, hValue, tail string, out any) error {
-	req, err := http.NewRequest("GET", s.url+tail, nil)
+	return s.GetWithHeaderContext(context.Background(), hKey, hValue, tail, out)
+}
+
+func (s *SimpleJSON) GetWithHeaderContext(ctx context.Context, hKey, hValue, tail string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url+tail, nil)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	req.Header.Set(hKey, hValue)
-
-	command, _ := common.GetCurlCommand(req)
-	log.Entry.WithField("tags", "request").Debug(command)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	return handleResponseForSimpleJSON(resp, out, s.handler)
+	return s.do(req, nil, out)
 }
 
 func (s *SimpleJSON) Post(tail string, in, out any) error {
+	return s.PostContext(context.Background(), tail, in, out)
+}
+
+func (s *SimpleJSON) PostContext(ctx context.Context, tail string, in, out any) error {
 	marshal, err := json.Marshal(in)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	req, err := http.NewRequest("POST", s.url+tail, bytes.NewReader(marshal))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url+tail, bytes.NewReader(marshal))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	if len(s.headers) != 0 {
-		for k, v := range s.headers {
-			req.Header.Set(k, v)
-		}
-	}
+	s.setHeaders(req)
 	req.Header.Set("Content-Type", "application/json")
+	return s.do(req, marshal, out)
+}
 
-	command, _ := common.GetCurlCommand(req)
-	log.Entry.WithField("tags", "request").Debug(command)
+func (s *SimpleJSON) PostString(tail, in string, out any) error {
+	return s.PostStringContext(context.Background(), tail, in, out)
+}
 
-	resp, err := s.client.Do(req)
+func (s *SimpleJSON) PostStringContext(ctx context.Context, tail, in string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url+tail, strings.NewReader(in))
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return handleResponseForSimpleJSON(resp, out, s.handler)
+	s.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(req, []byte(in), out)
 }
 
-func (s *SimpleJSON) PostString(tail, in string, out any) error {
-	req, err := http.NewRequest("POST", s.url+tail, strings.NewReader(in))
+func (s *SimpleJSON) PostShortConn(tail string, in, out any) error {
+	return s.PostShortConnContext(context.Background(), tail, in, out)
+}
+
+func (s *SimpleJSON) PostShortConnContext(ctx context.Context, tail string, in, out any) error {
+	marshal, err := json.Marshal(in)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	if len(s.headers) != 0 {
-		for k, v := range s.headers {
-			req.Header.Set(k, v)
-		}
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	command, _ := common.GetCurlCommand(req)
-	log.Entry.WithField("tags", "request").Debug(command)
 
-	resp, err := s.client.Do(req)
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url+tail, bytes.NewReader(marshal))
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return handleResponseForSimpleJSON(resp, out, s.handler)
+	s.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Close = true
+	return s.do(req, marshal, out)
 }
 
-func (s *SimpleJSON) PostShortConn(tail string, in, out any) error {
-	marshal, err := json.Marshal(in)
-	if err != nil {
-		return errors.WithStack(err)
+func (s *SimpleJSON) setHeaders(req *http.Request) {
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
 	}
+}
 
-	req, err := http.NewRequest("POST", s.url+tail, bytes.NewReader(marshal))
+func (s *SimpleJSON) do(req *http.Request, body []byte, out any) error {
+	resp, err := s.send(req, body)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
-	if len(s.headers) != 0 {
-		for k, v := range s.headers {
-			req.Header.Set(k, v)
+	return handleResponseForSimpleJSON(resp, out, s.handler, s.compression, s.maxBodySize)
+}
+
+// send 签名, 记录 debug 日志并发出请求
+func (s *SimpleJSON) send(req *http.Request, body []byte) (*http.Response, error) {
+	if s.compression {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	var redact []string
+	if s.signer != nil {
+		err := s.signer.Sign(req, body)
+		if err != nil {
+			return nil, err
 		}
+		redact = s.signer.SignedHeaders()
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Close = true
 
-	command, _ := common.GetCurlCommand(req)
+	command, _ := common.GetCurlCommand(req, redact...)
 	log.Entry.WithField("tags", "request").Debug(command)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, wrapContextErr(req.Context(), err)
 	}
-
-	return handleResponseForSimpleJSON(resp, out, s.handler)
+	return resp, nil
 }
 
-func handleResponseForSimpleJSON(resp *http.Response, out any, handler resultHandler) error {
+func handleResponseForSimpleJSON(resp *http.Response, out any, handler resultHandler,
+	compression bool, maxBodySize int64) error {
 	// nolint
 	defer resp.Body.Close()
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readBody(resp, compression, maxBodySize)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 	if resp.StatusCode != 200 {
-		bodyStr := string(bodyBytes)
-		if len(bodyBytes) > 500 {
-			bodyStr = string(bodyBytes[:150])
-			bodyStr = strings.ToValidUTF8(bodyStr, "") + "   凸(゜皿゜メ)"
-		}
-		return errors.Errorf("http status %d != 200\n%s", resp.StatusCode, bodyStr)
+		return statusError(resp.StatusCode, bodyBytes)
 	}
 	return handler(bodyBytes, out)
 }
@@ -1,9 +1,13 @@
 package http
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 var DefaultTS = &http.Transport{
@@ -23,3 +27,25 @@ type QueryParameter struct {
 	Key   string
 	Value string
 }
+
+/*
+请求因 context 中断时返回的错误, 可通过 errors.Is 判断
+同时 errors.Is(err, context.Canceled) 或 errors.Is(err, context.DeadlineExceeded) 依然成立
+*/
+var (
+	ErrCanceled         = errors.New("http request canceled")
+	ErrDeadlineExceeded = errors.New("http request deadline exceeded")
+)
+
+func wrapContextErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		return errors.WithStack(fmt.Errorf("%w: %w", ErrCanceled, err))
+	case context.DeadlineExceeded:
+		return errors.WithStack(fmt.Errorf("%w: %w", ErrDeadlineExceeded, err))
+	}
+	return errors.WithStack(err)
+}
@@ -0,0 +1,447 @@
+/*
+Package httpmock 为基于 net/http(SimpleJSON, ResultJSON) 和 net/rpc(Client) 的代码提供测试用的 mock server
+
+example:
+
+	s := httpmock.NewServer(t)
+	s.On("GET", "/block").Reply(200, map[string]any{"height": 1}).Times(1)
+	s.OnRPC("getblockcount").ReplyResult(100)
+	s.On("POST", "/tx").Status(502).Latency(time.Second)
+
+	client := http.NewSimpleJSON(s.URL())
+	...
+
+server 会在测试结束时自动关闭, 并检查 Times 设置的调用次数
+*/
+package httpmock
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Server struct {
+	t      testing.TB
+	server *httptest.Server
+
+	mutex   sync.Mutex
+	routes  []*Route
+	methods map[string]*Route // json-rpc method -> route
+	rpcPath string
+}
+
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		t:       t,
+		methods: make(map[string]*Route),
+		rpcPath: "/",
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(func() {
+		s.Close()
+		s.AssertExpectations()
+	})
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Close 关闭 server, 可重复调用
+func (s *Server) Close() {
+	s.server.CloseClientConnections()
+	s.server.Close()
+}
+
+// SetRPCPath 设置 json-rpc 请求的路径, 默认为 /
+func (s *Server) SetRPCPath(path string) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rpcPath = path
+	return s
+}
+
+// On 注册 http 路由, method 为空时匹配所有 method; path 不包含 query
+func (s *Server) On(method, path string) *Route {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := newRoute(method, path)
+	s.routes = append(s.routes, r)
+	return r
+}
+
+// OnRPC 注册 json-rpc method, 同时支持单个请求和批量请求
+func (s *Server) OnRPC(method string) *Route {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := newRoute("POST", method)
+	r.rpc = true
+	s.methods[method] = r
+	return r
+}
+
+// AssertExpectations 检查所有设置了 Times 的路由的调用次数
+func (s *Server) AssertExpectations() bool {
+	s.mutex.Lock()
+	routes := make([]*Route, 0, len(s.routes)+len(s.methods))
+	routes = append(routes, s.routes...)
+	for _, r := range s.methods {
+		routes = append(routes, r)
+	}
+	s.mutex.Unlock()
+
+	ok := true
+	for _, r := range routes {
+		ok = r.assertExpectation(s.t) && ok
+	}
+	return ok
+}
+
+func (s *Server) match(method, path string) (*Route, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, r := range s.routes {
+		if (r.method == "" || r.method == method) && r.path == path {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) isRPC(method, path string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return method == "POST" && path == s.rpcPath && len(s.methods) > 0
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.t.Errorf("httpmock: read body of %s %s: %v", req.Method, req.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	route, ok := s.match(req.Method, req.URL.Path)
+	if ok {
+		call := newCall(req, body)
+		route.record(call)
+		route.serve(w, func() (int, []byte) {
+			return route.respond(call)
+		})
+		return
+	}
+	if s.isRPC(req.Method, req.URL.Path) {
+		s.serveRPC(w, req, body)
+		return
+	}
+
+	s.t.Errorf("httpmock: unexpected request %s %s", req.Method, req.URL.String())
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// Call 记录一次请求
+type Call struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+
+	RPCMethod string          // 仅 json-rpc
+	Params    json.RawMessage // 仅 json-rpc
+}
+
+func newCall(req *http.Request, body []byte) *Call {
+	return &Call{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	}
+}
+
+// Bind 将请求体(json-rpc 时为 params)解析到 v
+func (c *Call) Bind(v any) error {
+	if c.RPCMethod != "" {
+		return json.Unmarshal(c.Params, v)
+	}
+	return json.Unmarshal(c.Body, v)
+}
+
+/*
+Route 一个 http 路由或者 json-rpc method 的期望及返回
+
+未设置返回内容时, http 路由返回 200 和 {}, json-rpc 返回 result: {}
+*/
+type Route struct {
+	method string
+	path   string // json-rpc 时为 method 名
+	rpc    bool
+
+	mutex     sync.Mutex
+	status    int
+	body      []byte
+	handler   func(*Call) (int, any)
+	rpcResult func(*Call) (any, error)
+	latency   time.Duration
+	forced    int // Status 设置的 status code, 0 表示不强制
+	malformed bool
+	drop      bool
+	expected  int // < 0 表示不检查调用次数
+	calls     []*Call
+}
+
+func newRoute(method, path string) *Route {
+	return &Route{
+		method:   method,
+		path:     path,
+		status:   http.StatusOK,
+		body:     []byte("{}"),
+		expected: -1,
+	}
+}
+
+// Reply 设置固定的返回, body 为 []byte 或 string 时原样返回, 否则按 json 编码
+func (r *Route) Reply(status int, body any) *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status = status
+	r.body = encode(body)
+	r.handler = nil
+	return r
+}
+
+/*
+ReplyResult 设置固定的结果
+
+http 路由返回 ResultJSON 格式的 {"result": result, "error": ""}
+json-rpc 返回 {"jsonrpc": "2.0", "id": id, "result": result}
+*/
+func (r *Route) ReplyResult(result any) *Route {
+	if r.rpc {
+		return r.ReplyRPC(func(*Call) (any, error) {
+			return result, nil
+		})
+	}
+	return r.Reply(http.StatusOK, map[string]any{"result": result, "error": ""})
+}
+
+// ReplyError http 路由返回 ResultJSON 格式的错误, json-rpc 返回 code 为 -32000 的错误
+func (r *Route) ReplyError(msg string) *Route {
+	if r.rpc {
+		return r.ReplyRPC(func(*Call) (any, error) {
+			return nil, &RPCError{Code: -32000, Message: msg}
+		})
+	}
+	return r.Reply(http.StatusOK, map[string]any{"result": nil, "error": msg})
+}
+
+// ReplyFunc 根据请求计算 http 返回, body 的处理同 Reply
+func (r *Route) ReplyFunc(f func(*Call) (int, any)) *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handler = f
+	return r
+}
+
+// ReplyRPC 根据请求计算 json-rpc 返回, err 为 *RPCError 时使用其 code, 否则 code 为 -32000
+func (r *Route) ReplyRPC(f func(*Call) (any, error)) *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rpcResult = f
+	return r
+}
+
+// Latency 每次请求在返回前等待 d
+func (r *Route) Latency(d time.Duration) *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.latency = d
+	return r
+}
+
+// Status 强制返回 status code, 用于模拟网关错误等; 优先于 Reply 及 ReplyFunc 的 status, 返回内容不变
+func (r *Route) Status(code int) *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.forced = code
+	return r
+}
+
+// Malformed 返回无法解析的 json
+func (r *Route) Malformed() *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.malformed = true
+	return r
+}
+
+// Drop 不返回任何内容, 直接断开连接
+func (r *Route) Drop() *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.drop = true
+	return r
+}
+
+// Times 期望的调用次数, 在 AssertExpectations 时检查
+func (r *Route) Times(n int) *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expected = n
+	return r
+}
+
+// Calls 返回所有已记录的请求
+func (r *Route) Calls() []*Call {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	calls := make([]*Call, len(r.calls))
+	copy(calls, r.calls)
+	return calls
+}
+
+func (r *Route) CallCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.calls)
+}
+
+// AssertBody 检查最后一次请求的请求体(json-rpc 时为 params)与 expected 按 json 比较是否相等
+func (r *Route) AssertBody(t testing.TB, expected any) bool {
+	calls := r.Calls()
+	if len(calls) == 0 {
+		return assert.Fail(t, "httpmock: no call", "%s %s", r.method, r.path)
+	}
+	last := calls[len(calls)-1]
+	actual := last.Body
+	if r.rpc {
+		actual = last.Params
+	}
+	return assert.JSONEq(t, string(encode(expected)), string(actual))
+}
+
+func (r *Route) record(call *Call) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *Route) assertExpectation(t testing.TB) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.expected < 0 {
+		return true
+	}
+	return assert.Equal(t, r.expected, len(r.calls), "httpmock: call count of %s %s", r.method, r.path)
+}
+
+func (r *Route) respond(call *Call) (int, []byte) {
+	r.mutex.Lock()
+	status, body, handler := r.status, r.body, r.handler
+	r.mutex.Unlock()
+	if handler == nil {
+		return status, body
+	}
+	code, res := handler(call)
+	return code, encode(res)
+}
+
+type failure struct {
+	latency   time.Duration
+	status    int // 0 表示使用 respond 的 status
+	malformed bool
+	drop      bool
+}
+
+func (r *Route) failure() failure {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return failure{
+		latency:   r.latency,
+		status:    r.forced,
+		malformed: r.malformed,
+		drop:      r.drop,
+	}
+}
+
+// serve 依次处理 latency, drop, status, malformed, 最后写入 respond 的结果
+func (r *Route) serve(w http.ResponseWriter, respond func() (int, []byte)) {
+	writeWithFailure(w, r.failure(), respond)
+}
+
+func writeWithFailure(w http.ResponseWriter, f failure, respond func() (int, []byte)) {
+	if f.latency > 0 {
+		time.Sleep(f.latency)
+	}
+	if f.drop {
+		dropConnection(w)
+		return
+	}
+	status, body := respond()
+	if f.status != 0 {
+		status = f.status
+	}
+	if f.malformed {
+		body = malformedBody
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+var malformedBody = []byte(`{"result": [1, 2, `)
+
+func dropConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	_ = conn.Close()
+}
+
+// encode []byte, string 原样返回, 其余按 json 编码
+func encode(v any) []byte {
+	switch value := v.(type) {
+	case nil:
+		return []byte("null")
+	case []byte:
+		return value
+	case string:
+		return []byte(value)
+	case json.RawMessage:
+		return value
+	}
+	return marshal(v)
+}
+
+// marshal 按 json 编码, 不转义 html 字符
+func marshal(v any) []byte {
+	if raw, ok := v.(json.RawMessage); ok {
+		return raw
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.TrimSpace(buf.Bytes())
+}
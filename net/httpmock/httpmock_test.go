@@ -0,0 +1,114 @@
+package httpmock_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	dhttp "github.com/LukeEuler/dolly/net/http"
+	"github.com/LukeEuler/dolly/net/httpmock"
+	"github.com/LukeEuler/dolly/net/rpc"
+)
+
+func TestServerHTTP(t *testing.T) {
+	s := httpmock.NewServer(t)
+	s.On("GET", "/block").Reply(http.StatusOK, map[string]int{"height": 10}).Times(1)
+	tx := s.On("POST", "/tx").ReplyFunc(func(c *httpmock.Call) (int, any) {
+		in := make(map[string]string)
+		assert.NoError(t, c.Bind(&in))
+		return http.StatusOK, map[string]string{"hash": in["raw"] + "-hash"}
+	}).Times(2)
+	s.On("GET", "/result").ReplyResult([]int{1, 2, 3})
+	s.On("GET", "/error").ReplyError("not found")
+
+	client := dhttp.NewSimpleJSON(s.URL())
+	block := make(map[string]int)
+	assert.NoError(t, client.Get("/block", &block))
+	assert.Equal(t, 10, block["height"])
+
+	res := make(map[string]string)
+	assert.NoError(t, client.Post("/tx", map[string]string{"raw": "a"}, &res))
+	assert.Equal(t, "a-hash", res["hash"])
+	assert.NoError(t, client.Post("/tx", map[string]string{"raw": "b"}, &res))
+	tx.AssertBody(t, `{"raw":"b"}`)
+	assert.Len(t, tx.Calls(), 2)
+
+	rj := dhttp.NewResultJSON(s.URL())
+	list := make([]int, 0)
+	assert.NoError(t, rj.Get("/result", &list))
+	assert.Equal(t, []int{1, 2, 3}, list)
+	assert.Error(t, rj.Get("/error", &list))
+}
+
+func TestServerFailures(t *testing.T) {
+	s := httpmock.NewServer(t)
+	s.On("GET", "/slow").Latency(100 * time.Millisecond)
+	s.On("GET", "/502").Status(http.StatusBadGateway)
+	s.On("GET", "/malformed").Malformed()
+	s.On("GET", "/drop").Drop()
+
+	client := dhttp.NewSimpleJSON(s.URL()).SetTimeout(20 * time.Millisecond)
+	out := make(map[string]any)
+	assert.Error(t, client.Get("/slow", &out))
+	assert.Error(t, client.Get("/502", &out))
+	assert.Error(t, client.Get("/malformed", &out))
+	assert.Error(t, client.Get("/drop", &out))
+}
+
+func TestServerStatus(t *testing.T) {
+	s := httpmock.NewServer(t)
+	s.On("GET", "/func").ReplyFunc(func(*httpmock.Call) (int, any) {
+		return http.StatusOK, map[string]int{"height": 1}
+	}).Status(http.StatusBadGateway)
+	s.On("GET", "/reply").Status(http.StatusServiceUnavailable).Reply(http.StatusOK, "{}")
+
+	// Status 优先于 ReplyFunc, 以及之后的 Reply
+	for path, code := range map[string]int{
+		"/func":  http.StatusBadGateway,
+		"/reply": http.StatusServiceUnavailable,
+	} {
+		resp, err := http.Get(s.URL() + path)
+		assert.NoError(t, err)
+		assert.Equal(t, code, resp.StatusCode, path)
+		_ = resp.Body.Close()
+	}
+}
+
+func TestServerRPC(t *testing.T) {
+	s := httpmock.NewServer(t)
+	s.OnRPC("getblockcount").ReplyResult(100).Times(3)
+	hash := s.OnRPC("getblockhash").ReplyRPC(func(c *httpmock.Call) (any, error) {
+		params := make([]int, 0)
+		if err := c.Bind(&params); err != nil {
+			return nil, err
+		}
+		if params[0] > 100 {
+			return nil, &httpmock.RPCError{Code: -8, Message: "Block height out of range"}
+		}
+		return "hash", nil
+	})
+
+	client, err := rpc.DialWithoutAuth(s.URL(), nil, rpc.JSONRPCVersion2)
+	assert.NoError(t, err)
+
+	var count int64
+	assert.NoError(t, client.SyncCall(&count, "getblockcount"))
+	assert.Equal(t, int64(100), count)
+
+	var blockHash string
+	assert.NoError(t, client.SyncCall(&blockHash, "getblockhash", 1))
+	assert.Equal(t, "hash", blockHash)
+	assert.Error(t, client.SyncCall(&blockHash, "getblockhash", 101))
+	hash.AssertBody(t, []int{101})
+	assert.Error(t, client.SyncCall(&blockHash, "unknown"))
+
+	counts := make([]int64, 2)
+	batch := []rpc.BatchElem{
+		{Method: "getblockcount", Result: &counts[0]},
+		{Method: "getblockcount", Result: &counts[1]},
+	}
+	assert.NoError(t, client.BatchSyncCall(batch))
+	assert.Equal(t, []int64{100, 100}, counts)
+}
@@ -0,0 +1,128 @@
+package httpmock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// RPCError json-rpc 错误返回
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("json-rpc error code: %d, msg: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+func (s *Server) serveRPC(w http.ResponseWriter, req *http.Request, body []byte) {
+	trimmed := bytes.TrimSpace(body)
+	batch := len(trimmed) > 0 && trimmed[0] == '['
+
+	var requests []*rpcRequest
+	var err error
+	if batch {
+		err = json.Unmarshal(trimmed, &requests)
+	} else {
+		single := new(rpcRequest)
+		err = json.Unmarshal(trimmed, single)
+		requests = []*rpcRequest{single}
+	}
+	if err != nil {
+		s.t.Errorf("httpmock: invalid json-rpc request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 批量请求时, 各 method 的故障注入合并生效: 最长的 latency, 任一 drop/malformed, 第一个强制的 status
+	var f failure
+	routes := make([]*Route, len(requests))
+	calls := make([]*Call, len(requests))
+	for i, item := range requests {
+		route, ok := s.rpcRoute(item.Method)
+		if !ok {
+			continue
+		}
+		call := newCall(req, body)
+		call.RPCMethod = item.Method
+		call.Params = item.Params
+		route.record(call)
+		routes[i], calls[i] = route, call
+
+		rf := route.failure()
+		f.latency = max(f.latency, rf.latency)
+		f.drop = f.drop || rf.drop
+		f.malformed = f.malformed || rf.malformed
+		if f.status == 0 {
+			f.status = rf.status
+		}
+	}
+
+	writeWithFailure(w, f, func() (int, []byte) {
+		responses := make([]*rpcResponse, len(requests))
+		for i, item := range requests {
+			responses[i] = rpcRespond(item, routes[i], calls[i])
+		}
+		if batch {
+			return http.StatusOK, encode(responses)
+		}
+		return http.StatusOK, encode(responses[0])
+	})
+}
+
+func (s *Server) rpcRoute(method string) (*Route, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.methods[method]
+	return r, ok
+}
+
+func rpcRespond(req *rpcRequest, route *Route, call *Call) *rpcResponse {
+	res := &rpcResponse{
+		Version: "2.0",
+		ID:      req.ID,
+	}
+	if route == nil {
+		res.Error = &RPCError{Code: -32601, Message: "method not found: " + req.Method}
+		return res
+	}
+
+	route.mutex.Lock()
+	f := route.rpcResult
+	route.mutex.Unlock()
+	if f == nil {
+		res.Result = json.RawMessage("{}")
+		return res
+	}
+
+	result, err := f(call)
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: -32000, Message: err.Error()}
+		}
+		res.Error = rpcErr
+		return res
+	}
+	res.Result = json.RawMessage(marshal(result))
+	return res
+}